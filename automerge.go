@@ -0,0 +1,62 @@
+package bitcask
+
+import (
+	"context"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// deadBytes returns the dead bytes and the size of the datafiles
+func (db *Bitcask) deadBytes() (dead, total int64) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	for _, df := range db.datafiles {
+		total += df.Size()
+		dead += df.Size() - df.live
+	}
+	return dead, total
+}
+
+// needMerge reports whether the dead bytes reach the auto merge thresholds
+func (db *Bitcask) needMerge() bool {
+	dead, total := db.deadBytes()
+	if dead <= 0 {
+		return false
+	}
+	if db.opts.mergeDeadBytes > 0 && dead >= db.opts.mergeDeadBytes {
+		return true
+	}
+	return db.opts.mergeRatio > 0 && float64(dead) >= db.opts.mergeRatio*float64(total)
+}
+
+// mergeLoop merges every merge interval when needed, until the db is closed
+func (db *Bitcask) mergeLoop() {
+	defer db.bg.Done()
+	ticker := time.NewTicker(db.opts.mergeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-db.closing:
+			return
+		case now := <-ticker.C:
+			if !db.opts.inMergeWindow(now) || !db.needMerge() {
+				continue
+			}
+			// merge is aborted with ErrClosed when the db is closing
+			stats, err := db.Merge(context.Background())
+			if err == ErrClosed || err == ErrMergeInProgress {
+				continue
+			}
+			if err != nil {
+				log.WithError(err).Error("auto merge")
+				continue
+			}
+			log.WithFields(log.Fields{
+				"keys":      stats.KeysRewritten,
+				"reclaimed": stats.BytesReclaimed,
+				"duration":  stats.Duration,
+			}).Info("auto merge")
+		}
+	}
+}
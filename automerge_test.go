@@ -0,0 +1,170 @@
+package bitcask
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDeadBytes(t *testing.T) {
+	dir := t.TempDir()
+	db := openPut(t, dir, 10)
+	var dead int64
+	for i := 0; i < 5; i++ {
+		if err := db.Put(GetKey(i), GetValue(i)); err != nil {
+			t.Fatal(err)
+		}
+		dead += int64(newDataEntry(GetKey(i), GetValue(i), markPut).Size())
+	}
+	for i := 5; i < 7; i++ {
+		if err := db.Del(GetKey(i)); err != nil {
+			t.Fatal(err)
+		}
+		// the deleted entry and the tombstone
+		dead += int64(newDataEntry(GetKey(i), GetValue(i), markPut).Size())
+		dead += int64(newDataEntry(GetKey(i), nil, markDel).Size())
+	}
+	if got, _ := db.deadBytes(); got != dead {
+		t.Fatalf("expected %d dead bytes, got %d", dead, got)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// rebuilt from the datafiles
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := db.deadBytes(); got != dead {
+		t.Fatalf("expected %d dead bytes, got %d", dead, got)
+	}
+	if _, err := db.Merge(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := db.deadBytes(); got != 0 {
+		t.Fatalf("expected no dead bytes after merge, got %d", got)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// rebuilt from the hint files
+	db, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if got, _ := db.deadBytes(); got != 0 {
+		t.Fatalf("expected no dead bytes after merge, got %d", got)
+	}
+}
+
+func TestAutoMerge(t *testing.T) {
+	db := openPut(t, t.TempDir(), 100, WithAutoMerge(10*time.Millisecond, 0.5, 0))
+	defer db.Close()
+	// below the ratio
+	for i := 0; i < 40; i++ {
+		if err := db.Put(GetKey(i), GetValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	if dead, _ := db.deadBytes(); dead == 0 {
+		t.Fatal("unexpected merge below the ratio")
+	}
+
+	for i := 0; i < 100; i++ {
+		if err := db.Put(GetKey(i), GetValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		dead, _ := db.deadBytes()
+		if dead == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected auto merge, %d dead bytes", dead)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for i := 0; i < 100; i++ {
+		val, err := db.Get(GetKey(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(val) != string(GetValue(i)) {
+			t.Fatalf("expected %s, got %s", GetValue(i), val)
+		}
+	}
+}
+
+func TestAutoMergeDeadBytes(t *testing.T) {
+	entrySize := int64(newDataEntry(GetKey(0), GetValue(0), markPut).Size())
+	db := openPut(t, t.TempDir(), 100, WithAutoMerge(10*time.Millisecond, 0, 3*entrySize))
+	defer db.Close()
+	for i := 0; i < 3; i++ {
+		if err := db.Put(GetKey(i), GetValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		dead, _ := db.deadBytes()
+		if dead == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected auto merge, %d dead bytes", dead)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAutoMergeWindow(t *testing.T) {
+	now := time.Now()
+	y, m, d := now.Date()
+	since := now.Sub(time.Date(y, m, d, 0, 0, 0, 0, now.Location()))
+	// a window that is not now
+	start := (since + time.Hour) % (24 * time.Hour)
+	end := (since + 2*time.Hour) % (24 * time.Hour)
+	db := openPut(t, t.TempDir(), 10, WithAutoMerge(10*time.Millisecond, 0.1, 0), WithMergeWindow(start, end))
+	defer db.Close()
+	for i := 0; i < 10; i++ {
+		if err := db.Put(GetKey(i), GetValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	if dead, _ := db.deadBytes(); dead == 0 {
+		t.Fatal("unexpected merge out of the window")
+	}
+}
+
+func TestInMergeWindow(t *testing.T) {
+	at := func(hour int) time.Time {
+		return time.Date(2021, 10, 1, hour, 30, 0, 0, time.Local)
+	}
+	tests := []struct {
+		start, end time.Duration
+		hour       int
+		want       bool
+	}{
+		{0, 0, 12, true},
+		{2 * time.Hour, 4 * time.Hour, 3, true},
+		{2 * time.Hour, 4 * time.Hour, 4, false},
+		{2 * time.Hour, 4 * time.Hour, 1, false},
+		{22 * time.Hour, 2 * time.Hour, 23, true},
+		{22 * time.Hour, 2 * time.Hour, 1, true},
+		{22 * time.Hour, 2 * time.Hour, 12, false},
+	}
+	for _, tt := range tests {
+		o := defaultOptions()
+		WithMergeWindow(tt.start, tt.end)(o)
+		if got := o.inMergeWindow(at(tt.hour)); got != tt.want {
+			t.Fatalf("window [%v, %v) at %d:30: expected %v, got %v", tt.start, tt.end, tt.hour, tt.want, got)
+		}
+	}
+}
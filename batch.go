@@ -0,0 +1,68 @@
+package bitcask
+
+// Batch is a set of Put and Delete applied atomically by Bitcask.Write.
+// The entries are appended with a COMMIT entry, and the index is rebuilt
+// with either all or none of them after a crash.
+type Batch struct {
+	entries []*dataEntry
+}
+
+func NewBatch() *Batch {
+	return &Batch{}
+}
+
+// Put adds or updates the value of key in the batch
+func (b *Batch) Put(key, value []byte) {
+	b.add(key, value, markPut)
+}
+
+// Delete deletes key in the batch, deleting a missing key is not an error
+func (b *Batch) Delete(key []byte) {
+	b.add(key, nil, markDel)
+}
+
+// Len returns the number of Put and Delete in the batch
+func (b *Batch) Len() int {
+	return len(b.entries)
+}
+
+// Reset empties the batch for reuse
+func (b *Batch) Reset() {
+	b.entries = b.entries[:0]
+}
+
+func (b *Batch) add(key, value []byte, mark uint8) {
+	// the caller may reuse key and value before the batch is written
+	k := make([]byte, len(key))
+	copy(k, key)
+	var v []byte
+	if value != nil {
+		v = make([]byte, len(value))
+		copy(v, value)
+	}
+	b.entries = append(b.entries, newDataEntry(k, v, mark|markBatch))
+}
+
+// Write applies the batch atomically
+func (db *Bitcask) Write(b *Batch) error {
+	if b.Len() == 0 {
+		return nil
+	}
+	for _, e := range b.entries {
+		if e.keySize > db.opts.maxKeySize {
+			return ErrKeyTooLarge
+		}
+		if e.valueSize > db.opts.maxValueSize {
+			return ErrValueTooLarge
+		}
+	}
+	return db.write(b.request())
+}
+
+// request returns the write request of the entries and the commit entry
+func (b *Batch) request() *writeRequest {
+	entries := make([]*dataEntry, 0, b.Len()+1)
+	entries = append(entries, b.entries...)
+	entries = append(entries, newCommitEntry(b.Len()))
+	return newWriteRequest(entries...)
+}
@@ -0,0 +1,147 @@
+package bitcask
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestWriteBatch(t *testing.T) {
+	dir := t.TempDir()
+	db := openPut(t, dir, 3)
+
+	b := NewBatch()
+	b.Put(GetKey(0), []byte("new"))
+	b.Delete(GetKey(1))
+	b.Put(GetKey(3), GetValue(3))
+	// deleting a missing key is not an error
+	b.Delete(GetKey(4))
+	if err := db.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	check := func(db *Bitcask) {
+		if db.Keys() != 3 {
+			t.Fatalf("expected 3 keys, got %d", db.Keys())
+		}
+		val, err := db.Get(GetKey(0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(val) != "new" {
+			t.Fatalf("expected new, got %s", val)
+		}
+		if _, err := db.Get(GetKey(1)); !errors.Is(err, ErrKeyNotFound) {
+			t.Fatalf("expected ErrKeyNotFound, got %v", err)
+		}
+	}
+	check(db)
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	check(db)
+}
+
+func TestWriteBatchTooLarge(t *testing.T) {
+	db, err := Open(t.TempDir(), WithMaxValueSize(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	b := NewBatch()
+	b.Put([]byte("k1"), []byte("v1"))
+	b.Put([]byte("k2"), []byte("value"))
+	if err := db.Write(b); !errors.Is(err, ErrValueTooLarge) {
+		t.Fatalf("expected ErrValueTooLarge, got %v", err)
+	}
+	if db.Keys() != 0 {
+		t.Fatalf("expected 0 keys, got %d", db.Keys())
+	}
+}
+
+func TestRecoverUncommittedBatch(t *testing.T) {
+	dir := t.TempDir()
+	db := openPut(t, dir, 3)
+	name, size := db.active.f.Name(), db.active.Size()
+
+	b := NewBatch()
+	b.Put(GetKey(0), []byte("new"))
+	b.Delete(GetKey(1))
+	// the batch entries are written without the commit entry
+	for _, e := range b.entries {
+		if _, err := db.active.Write(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	val, err := db.Get(GetKey(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(val) != string(GetValue(0)) {
+		t.Fatalf("expected %s, got %s", GetValue(0), val)
+	}
+	if db.Keys() != 3 {
+		t.Fatalf("expected 3 keys, got %d", db.Keys())
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() != size {
+		t.Fatalf("expected datafile truncated to %d, got %d", size, fi.Size())
+	}
+}
+
+func TestRecoverTornBatchCommit(t *testing.T) {
+	dir := t.TempDir()
+	db := openPut(t, dir, 3)
+	name := db.active.f.Name()
+
+	b := NewBatch()
+	b.Put(GetKey(0), []byte("new"))
+	b.Delete(GetKey(1))
+	if err := db.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	size := db.active.Size()
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// cut the commit entry
+	if err := os.Truncate(name, size-1); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if db.Keys() != 3 {
+		t.Fatalf("expected 3 keys, got %d", db.Keys())
+	}
+	val, err := db.Get(GetKey(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(val) != string(GetValue(0)) {
+		t.Fatalf("expected %s, got %s", GetValue(0), val)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/Qraffa/bitcask"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func init() {
+	log.SetFormatter(&log.TextFormatter{
+		ForceColors: true,
+	})
+}
+
+var errUsage = errors.New("usage")
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s [-dir dir] <command> [args]\n\n", os.Args[0])
+	fmt.Fprintln(os.Stderr, "commands:")
+	fmt.Fprintln(os.Stderr, "  put <key> <value>")
+	fmt.Fprintln(os.Stderr, "  get <key>")
+	fmt.Fprintln(os.Stderr, "  del <key>")
+	fmt.Fprintln(os.Stderr, "  scan [prefix]")
+	fmt.Fprintln(os.Stderr, "  merge")
+	fmt.Fprintln(os.Stderr, "  keys")
+	fmt.Fprintln(os.Stderr)
+	flag.PrintDefaults()
+}
+
+func main() {
+	dir := flag.String("dir", "", "db directory")
+	flag.Usage = usage
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	db, err := bitcask.Open(*dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := run(db, args); err != nil {
+		db.Close()
+		if err == errUsage {
+			usage()
+			os.Exit(2)
+		}
+		log.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(db *bitcask.Bitcask, args []string) error {
+	cmd, args := args[0], args[1:]
+	switch {
+	case cmd == "put" && len(args) == 2:
+		return db.Put([]byte(args[0]), []byte(args[1]))
+	case cmd == "get" && len(args) == 1:
+		val, err := db.Get([]byte(args[0]))
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(val))
+		return nil
+	case cmd == "del" && len(args) == 1:
+		return db.Del([]byte(args[0]))
+	case cmd == "scan" && len(args) <= 1:
+		var prefix []byte
+		if len(args) == 1 {
+			prefix = []byte(args[0])
+		}
+		return db.Scan(prefix, func(key, value []byte) error {
+			fmt.Printf("%s %s\n", key, value)
+			return nil
+		})
+	case cmd == "merge" && len(args) == 0:
+		stats, err := db.Merge(context.Background())
+		if err != nil {
+			return err
+		}
+		fmt.Printf("rewrote %d keys, reclaimed %d bytes in %v\n", stats.KeysRewritten, stats.BytesReclaimed, stats.Duration)
+		return nil
+	case cmd == "keys" && len(args) == 0:
+		fmt.Println(db.Keys())
+		return nil
+	}
+	return errUsage
+}
@@ -0,0 +1,231 @@
+package bitcask
+
+import log "github.com/sirupsen/logrus"
+
+// max bytes written by a commit group, the leader's request is always in
+const maxGroupSize = 4 << 20
+
+// writeRequest is the entries appended by a Put or Del. Concurrent requests
+// are queued and committed as a group by the request at the head of the
+// queue, with one write and one sync for the whole group.
+type writeRequest struct {
+	entries []*dataEntry
+	bufs    [][]byte
+	size    int64
+	// check is called before the entries are written, with the index
+	// as updated by the requests ahead in the group. A non nil error
+	// fails this request only.
+	check func(lookup lookupFunc) error
+	err   error
+	done  bool
+}
+
+// lookupFunc returns the item of key, nil if the key is absent, and the
+// seq of the last change of key
+type lookupFunc func(key string) (*item, uint64)
+
+// pendingItem is the change of a key by a request in the group
+type pendingItem struct {
+	// nil means deleted
+	it  *item
+	seq uint64
+}
+
+func newWriteRequest(entries ...*dataEntry) *writeRequest {
+	req := &writeRequest{
+		entries: entries,
+		bufs:    make([][]byte, len(entries)),
+	}
+	// encode out of the lock
+	for i, e := range entries {
+		n, buf := e.Encode()
+		req.bufs[i] = buf
+		req.size += int64(n)
+	}
+	return req
+}
+
+// write queues req and waits until it is committed
+func (db *Bitcask) write(req *writeRequest) error {
+	if db.opts.readOnly {
+		return ErrReadOnly
+	}
+	db.wmu.Lock()
+	db.writers = append(db.writers, req)
+	for !req.done && req != db.writers[0] {
+		db.wcond.Wait()
+	}
+	// committed by the leader
+	if req.done {
+		db.wmu.Unlock()
+		return req.err
+	}
+	// req is the leader, take the queued requests as a group
+	var size int64
+	n := 0
+	for _, r := range db.writers {
+		if n > 0 && size+r.size > maxGroupSize {
+			break
+		}
+		size += r.size
+		n++
+	}
+	group := db.writers[:n]
+	db.wmu.Unlock()
+
+	db.commit(group)
+
+	db.wmu.Lock()
+	for _, r := range group {
+		r.done = true
+	}
+	db.writers = db.writers[n:]
+	db.wcond.Broadcast()
+	db.wmu.Unlock()
+	return req.err
+}
+
+// commit appends the entries of group to the active datafile with one write
+// and one sync, then updates the index. The error of each request is set in
+// its err.
+func (db *Bitcask) commit(group []*writeRequest) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	// the requests before applied are written and in the index
+	applied := 0
+	fail := func(err error) {
+		for _, req := range group[applied:] {
+			if req.err == nil {
+				req.err = err
+			}
+		}
+	}
+	if db.closed {
+		fail(ErrClosed)
+		return
+	}
+	if !db.active.isActive {
+		fail(ErrDataFileNotActive)
+		return
+	}
+
+	pending := make(map[string]pendingItem)
+	tombstones := make(map[*dataFile]int64)
+	lookup := func(key string) (*item, uint64) {
+		if p, ok := pending[key]; ok {
+			return p.it, p.seq
+		}
+		return db.lookup(key)
+	}
+	buf := make([]byte, 0)
+	flush := func() error {
+		if len(buf) == 0 {
+			return nil
+		}
+		err := db.active.writeRaw(buf)
+		buf = buf[:0]
+		return err
+	}
+	// offset of the active datafile where the entries not applied start
+	start := db.active.Size()
+	// apply updates the index with the written entries of the requests
+	// before to
+	apply := func(to int) {
+		for df, n := range tombstones {
+			df.tombstones += n
+		}
+		for k, p := range pending {
+			// the replaced states are kept for the snapshots of the open
+			// transactions
+			if db.txs > 0 {
+				db.addVersion(k, p.it, p.seq)
+			}
+			if p.it == nil {
+				db.deleteItem(k)
+				continue
+			}
+			db.putItem(k, p.it)
+		}
+		pending = make(map[string]pendingItem)
+		tombstones = make(map[*dataFile]int64)
+		applied = to
+		start = db.active.Size()
+	}
+	for i, req := range group {
+		if req.check != nil {
+			if err := req.check(lookup); err != nil {
+				req.err = err
+				continue
+			}
+		}
+		// entries of a request are kept in one datafile, so a batch
+		// is never split by sealing the active datafile
+		if db.active.Size()+int64(len(buf))+req.size >= db.opts.maxFileSize {
+			// write the buffered entries before the active datafile is sealed
+			if err := flush(); err != nil {
+				fail(err)
+				return
+			}
+			// the requests ahead are written even if the rotation fails
+			err := db.checkIfNeeded(req.size, false)
+			apply(i)
+			if err != nil {
+				fail(err)
+				return
+			}
+		}
+		db.seq++
+		for i, e := range req.entries {
+			offset := db.active.Size() + int64(len(buf))
+			buf = append(buf, req.bufs[i]...)
+			switch e.op() {
+			case markCommit:
+				continue
+			case markDel:
+				pending[string(e.key)] = pendingItem{seq: db.seq}
+				tombstones[db.active] += int64(len(req.bufs[i]))
+				continue
+			}
+			pending[string(e.key)] = pendingItem{
+				it: &item{
+					fileID:      db.currID,
+					entryOffset: offset,
+					seq:         db.seq,
+					expiry:      e.expiry,
+					size:        int64(len(req.bufs[i])),
+				},
+				seq: db.seq,
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		fail(err)
+		return
+	}
+	if db.opts.syncPolicy == SyncAlways {
+		if err := db.active.Sync(); err != nil {
+			// the failed requests must not be loaded after a restart
+			if terr := db.active.truncate(start); terr != nil {
+				log.WithError(terr).WithField("datafile", db.active.f.Name()).Error("truncate datafile after failed sync")
+				// refuse the writes after the entries of the failed requests
+				db.active.isActive = false
+			}
+			fail(err)
+			return
+		}
+	}
+	apply(len(group))
+}
+
+// lookup returns the item of key, nil if the key is absent, and the seq of
+// the last change of key. The seq of a deletion is only known when it is
+// made while a transaction is open, otherwise it is 0.
+func (db *Bitcask) lookup(key string) (*item, uint64) {
+	if it, ok := db.index.Get(key); ok {
+		return it, it.seq
+	}
+	if vs, ok := db.versions[key]; ok {
+		return nil, vs[len(vs)-1].seq
+	}
+	return nil, 0
+}
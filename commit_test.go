@@ -0,0 +1,191 @@
+package bitcask
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sync"
+	"testing"
+)
+
+func TestCommitGroup(t *testing.T) {
+	db, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	del := func(key string) *writeRequest {
+		req := newWriteRequest(newDataEntry([]byte(key), nil, markDel))
+		req.check = func(lookup lookupFunc) error {
+			if it, _ := lookup(key); it == nil {
+				return ErrKeyNotFound
+			}
+			return nil
+		}
+		return req
+	}
+	group := []*writeRequest{
+		newWriteRequest(newDataEntry([]byte("k1"), []byte("v1"), markPut)),
+		// sees k1 put by the request ahead
+		del("k1"),
+		// k2 is not put yet
+		del("k2"),
+		newWriteRequest(newDataEntry([]byte("k2"), []byte("v2"), markPut)),
+	}
+	db.commit(group)
+
+	for i, want := range []error{nil, nil, ErrKeyNotFound, nil} {
+		if !errors.Is(group[i].err, want) {
+			t.Fatalf("request %d: expected %v, got %v", i, want, group[i].err)
+		}
+	}
+	var size int64
+	for _, i := range []int{0, 1, 3} {
+		size += group[i].size
+	}
+	if db.active.Size() != size {
+		t.Fatalf("expected %d bytes written, got %d", size, db.active.Size())
+	}
+	if _, err := db.Get([]byte("k1")); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+	val, err := db.Get([]byte("k2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(val) != "v2" {
+		t.Fatalf("expected v2, got %s", val)
+	}
+}
+
+func TestGroupCommitConcurrent(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, WithSyncPolicy(SyncAlways), WithMaxFileSize(4096))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wg := sync.WaitGroup{}
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				key := []byte(fmt.Sprintf("gor-%d-key-%d", g, i))
+				val := []byte(fmt.Sprintf("gor-%d-value-%d", g, i))
+				if err := db.Put(key, val); err != nil {
+					t.Error(err)
+					return
+				}
+				if i%2 == 0 {
+					continue
+				}
+				if err := db.Del(key); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if db.Keys() != 400 {
+		t.Fatalf("expected 400 keys, got %d", db.Keys())
+	}
+	for g := 0; g < 8; g++ {
+		for i := 0; i < 100; i += 2 {
+			val, err := db.Get([]byte(fmt.Sprintf("gor-%d-key-%d", g, i)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(val) != fmt.Sprintf("gor-%d-value-%d", g, i) {
+				t.Fatalf("unexpected value %s", val)
+			}
+		}
+	}
+}
+
+func TestCommitRotateFail(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, WithMaxFileSize(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	// the next datafile can't be created
+	if err := os.Mkdir(path.Join(dir, fmt.Sprintf(dataFilePrefix, db.currID+1)), 0755); err != nil {
+		t.Fatal(err)
+	}
+	group := []*writeRequest{
+		newWriteRequest(newDataEntry([]byte("k1"), []byte("v1"), markPut)),
+		// fills the active datafile
+		newWriteRequest(newDataEntry([]byte("k2"), make([]byte, 80), markPut)),
+	}
+	db.commit(group)
+
+	if group[0].err != nil {
+		t.Fatalf("expected the written request to succeed, got %v", group[0].err)
+	}
+	if group[1].err == nil {
+		t.Fatal("expected the request after the failed rotation to fail")
+	}
+	val, err := db.Get([]byte("k1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(val) != "v1" {
+		t.Fatalf("expected v1, got %s", val)
+	}
+	if _, err := db.Get([]byte("k2")); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+}
+
+func TestCommitSyncFail(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, WithSyncPolicy(SyncAlways))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put([]byte("k1"), []byte("v1")); err != nil {
+		t.Fatal(err)
+	}
+	size := db.active.Size()
+
+	errSync := errors.New("sync failed")
+	fileSync = func(*os.File) error { return errSync }
+	err = db.Put([]byte("k2"), []byte("v2"))
+	fileSync = (*os.File).Sync
+	if !errors.Is(err, errSync) {
+		t.Fatalf("expected the sync error, got %v", err)
+	}
+	// the entries of the failed request are discarded
+	if db.active.Size() != size {
+		t.Fatalf("expected the datafile truncated to %d, got %d", size, db.active.Size())
+	}
+	if err := db.Put([]byte("k3"), []byte("v3")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Get([]byte("k2")); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+	checkValues(t, db, map[string]string{"k1": "v1", "k3": "v3"})
+}
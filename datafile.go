@@ -1,8 +1,8 @@
-package main
+package bitcask
 
 import (
-	"errors"
 	"fmt"
+	"io"
 	"math"
 	"os"
 	"path"
@@ -13,19 +13,35 @@ const (
 	dataFilePrefix  = "bitcask.data.%d"
 )
 
-type DataFile struct {
+type dataFile struct {
 	f        *os.File
 	fileID   int64
 	offset   int64
 	isActive bool
+	// number of iterators reading the datafile, guarded by db.mu
+	refs int
+	// removed from the db, deleted when the last iterator is closed
+	retired bool
+	// bytes of the entries in the index, guarded by db.mu. The rest of
+	// the datafile is dead and reclaimed by merge.
+	live int64
+	// bytes of the tombstones, guarded by db.mu. Only a full merge
+	// reclaims them.
+	tombstones int64
+	// replaced by a rewritten datafile of the same id, so it is only
+	// closed when retired
+	replaced bool
+	// the sealed datafile mapped by mmap, nil if read with pread
+	data []byte
+	// the entries read share the mapped memory
+	zeroCopy bool
 }
 
-func NewDataFile(dir string, id int64, active bool) (*DataFile, error) {
+// perm is only used when creating the active datafile
+func openDataFile(dir string, id int64, active bool, perm os.FileMode) (*dataFile, error) {
 	var flag int
-	var perm os.FileMode
 	if active {
 		flag = os.O_CREATE | os.O_APPEND | os.O_RDWR
-		perm = os.ModePerm
 	} else {
 		flag = os.O_RDONLY
 		perm = 0
@@ -35,15 +51,20 @@ func NewDataFile(dir string, id int64, active bool) (*DataFile, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &DataFile{
+	fi, err := fd.Stat()
+	if err != nil {
+		fd.Close()
+		return nil, err
+	}
+	return &dataFile{
 		f:        fd,
 		fileID:   id,
-		offset:   0,
+		offset:   fi.Size(),
 		isActive: active,
 	}, nil
 }
 
-func newDataFile(file string, active bool) (*DataFile, error) {
+func newDataFile(file string, active bool) (*dataFile, error) {
 	var flag int
 	var perm os.FileMode
 	if active {
@@ -57,59 +78,235 @@ func newDataFile(file string, active bool) (*DataFile, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &DataFile{
+	return &dataFile{
 		f:        fd,
 		offset:   0,
 		isActive: true,
 	}, nil
 }
 
-func (d *DataFile) Close() error {
+// mmap maps the sealed datafile, so the entries are read from memory
+// without syscalls. The key and value of the entries read share the mapped
+// memory if zeroCopy, and are copied otherwise. The active datafile is
+// still read with pread, so is the datafile if mmap is not supported.
+func (d *dataFile) mmap(zeroCopy bool) error {
+	if d.f == nil {
+		return ErrDataFileClosed
+	}
+	if d.isActive || d.data != nil || d.offset == 0 {
+		return nil
+	}
+	data, err := mmap(d.f, d.offset)
+	if err != nil {
+		return err
+	}
+	d.data = data
+	d.zeroCopy = zeroCopy && data != nil
+	return nil
+}
+
+// Close unmaps and closes the datafile, the entries read with zero copy
+// are invalid after it
+func (d *dataFile) Close() error {
+	if d.data != nil {
+		if err := munmap(d.data); err != nil {
+			return err
+		}
+		d.data = nil
+	}
 	return d.f.Close()
 }
 
-func (d *DataFile) Size() int64 {
-	return d.offset
+// fileSync syncs the datafile, replaced by tests to fail
+var fileSync = (*os.File).Sync
+
+func (d *dataFile) Sync() error {
+	if d.f == nil {
+		return ErrDataFileClosed
+	}
+	return fileSync(d.f)
 }
 
-func (d *DataFile) ReadAt(offset int64) (int64, *Entry, error) {
+// truncate discards the datafile content after size
+func (d *dataFile) truncate(size int64) error {
 	if d.f == nil {
+		return ErrDataFileClosed
+	}
+	// the sealed datafile is read only
+	if err := os.Truncate(d.f.Name(), size); err != nil {
+		return err
+	}
+	d.offset = size
+	return nil
+}
 
+func (d *dataFile) Size() int64 {
+	return d.offset
+}
+
+// ReadAt reads the entry at offset and verifies its crc.
+// It returns io.EOF at the end of the datafile, io.ErrUnexpectedEOF if the
+// entry is truncated and a *CorruptEntryError with the entry size if the
+// crc mismatches.
+func (d *dataFile) ReadAt(offset int64) (int64, *dataEntry, error) {
+	if d.f == nil {
+		return 0, nil, ErrDataFileClosed
+	}
+	if offset >= d.offset {
+		return 0, nil, io.EOF
+	}
+	if offset+metaLen > d.offset {
+		return 0, nil, io.ErrUnexpectedEOF
 	}
 	// read k-v meta
-	metaBuf := make([]byte, metaLen)
-	metaOffset, err := d.f.ReadAt(metaBuf, offset)
+	metaBuf, err := d.read(offset, metaLen)
 	if err != nil {
 		return 0, nil, err
 	}
-	e := &Entry{}
+	e := &dataEntry{}
 	e.DecodeMeta(metaBuf)
+	meta := int64(e.metaSize())
+	// don't trust the sizes before the crc is checked
+	if offset+meta > d.offset || uint64(e.keySize)+e.valueSize > uint64(d.offset-offset-meta) {
+		return 0, nil, io.ErrUnexpectedEOF
+	}
+	if meta > metaLen {
+		if metaBuf, err = d.read(offset, meta); err != nil {
+			return 0, nil, err
+		}
+		e.decodeExpiry(metaBuf)
+	}
 
-	kvBuf := make([]byte, uint64(e.keySize)+e.valueSize)
-	kvOffset, err := d.f.ReadAt(kvBuf, offset+metaLen)
+	kvLen := int64(e.keySize) + int64(e.valueSize)
+	kvBuf, err := d.read(offset+meta, kvLen)
 	if err != nil {
 		return 0, nil, err
 	}
-	e.DecodeKV(kvBuf)
-	return int64(metaOffset + kvOffset), e, nil
+	if !e.checkCRC(metaBuf, kvBuf) {
+		// return the entry size, so the caller can skip it
+		return meta + kvLen, nil, &CorruptEntryError{FileID: d.fileID, Offset: offset}
+	}
+	if d.zeroCopy {
+		e.decodeKVView(kvBuf)
+	} else {
+		e.DecodeKV(kvBuf)
+	}
+	return meta + kvLen, e, nil
+}
+
+// readEntry reads the entry of size bytes at offset with a single read,
+// size is the entry size recorded in the index. It returns a
+// *CorruptEntryError if the entry doesn't have the size or fails the crc.
+func (d *dataFile) readEntry(offset, size int64) (*dataEntry, error) {
+	if d.f == nil {
+		return nil, ErrDataFileClosed
+	}
+	if size < metaLen || offset+size > d.offset {
+		return nil, io.ErrUnexpectedEOF
+	}
+	buf, err := d.read(offset, size)
+	if err != nil {
+		return nil, err
+	}
+	e := &dataEntry{}
+	e.DecodeMeta(buf)
+	meta := e.metaSize()
+	if e.Size() != uint64(size) || !e.checkCRC(buf[:meta], buf[meta:]) {
+		return nil, &CorruptEntryError{FileID: d.fileID, Offset: offset}
+	}
+	if meta > metaLen {
+		e.decodeExpiry(buf)
+	}
+	if d.zeroCopy {
+		e.decodeKVView(buf[meta:])
+	} else {
+		e.DecodeKV(buf[meta:])
+	}
+	return e, nil
+}
+
+// readValue reads the value of the entry of key, size bytes at offset,
+// without reading the key. expires tells if the entry has an expiry. If
+// verify, the meta of the entry is read too and the crc is checked with key.
+func (d *dataFile) readValue(offset, size int64, key []byte, expires, verify bool) ([]byte, error) {
+	if d.f == nil {
+		return nil, ErrDataFileClosed
+	}
+	var mark uint8
+	if expires {
+		mark = markExpiry
+	}
+	meta := int64(entryMetaSize(mark))
+	valueOffset := offset + meta + int64(len(key))
+	if valueOffset > offset+size || offset+size > d.offset {
+		return nil, io.ErrUnexpectedEOF
+	}
+	value, err := d.read(valueOffset, offset+size-valueOffset)
+	if err != nil {
+		return nil, err
+	}
+	if verify {
+		metaBuf, err := d.read(offset, meta)
+		if err != nil {
+			return nil, err
+		}
+		e := &dataEntry{}
+		e.DecodeMeta(metaBuf)
+		if e.metaSize() != uint64(meta) || e.Size() != uint64(size) || int(e.keySize) != len(key) || !e.checkCRC(metaBuf, key, value) {
+			return nil, &CorruptEntryError{FileID: d.fileID, Offset: offset}
+		}
+	}
+	// the mapped memory is copied unless zero copy
+	if !d.zeroCopy && offset+size <= int64(len(d.data)) {
+		value = append([]byte(nil), value...)
+	}
+	return value, nil
+}
+
+// read returns n bytes at offset, from the mapped memory if they are mapped
+func (d *dataFile) read(offset, n int64) ([]byte, error) {
+	if end := offset + n; end <= int64(len(d.data)) {
+		return d.data[offset:end:end], nil
+	}
+	buf := make([]byte, n)
+	if _, err := d.f.ReadAt(buf, offset); err != nil {
+		return nil, err
+	}
+	return buf, nil
 }
 
 // assumed the file size is much smaller than 1 << 64
 // so offset never overflow
-func (d *DataFile) Write(e *Entry) (int64, error) {
-	if d.f == nil || !d.isActive {
+func (d *dataFile) Write(e *dataEntry) (int64, error) {
+	_, buf := e.Encode()
+	offset := d.offset
+	if err := d.writeRaw(buf); err != nil {
+		return 0, err
+	}
+	return offset, nil
+}
 
+// writeRaw appends the encoded entries in buf
+func (d *dataFile) writeRaw(buf []byte) error {
+	if d.f == nil {
+		return ErrDataFileClosed
 	}
-	n, buf := e.Encode()
+	if !d.isActive {
+		return ErrDataFileNotActive
+	}
+	n := uint64(len(buf))
 	// 1<<64 file too large, don't consider
 
 	if uint64(d.offset)+n > uint64(math.MaxInt64) {
 		// k-v too large
 		d.isActive = false
-		return d.offset, errors.New("")
+		return ErrDataFileOverflow
+	}
+	if _, err := d.f.Write(buf); err != nil {
+		// drop the partial write, so the next entry starts at offset
+		d.f.Truncate(d.offset)
+		return err
 	}
-	offset := d.offset
-	d.f.Write(buf)
 	d.offset += int64(n)
-	return offset, nil
+	return nil
 }
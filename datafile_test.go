@@ -1,19 +1,21 @@
-package main
+package bitcask
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path"
 	"testing"
 )
 
 func TestDatafile(t *testing.T) {
-	df, err := NewDataFile(defaultDir, 99, true)
+	df, err := openDataFile(defaultDir, 99, true, defaultFileMode)
 	if err != nil {
 		panic(err)
 	}
 
-	e := NewEntry([]byte("key"), []byte("value"), PUT)
+	e := newDataEntry([]byte("key"), []byte("value"), markPut)
 
 	if _, err := df.Write(e); err != nil {
 		panic(err)
@@ -28,7 +30,7 @@ func TestDatafile(t *testing.T) {
 }
 
 func TestRead(t *testing.T) {
-	df, err := NewDataFile(defaultDir, 99, true)
+	df, err := openDataFile(defaultDir, 99, true, defaultFileMode)
 	fmt.Println(df.offset)
 	if err != nil {
 		panic(err)
@@ -48,3 +50,131 @@ func TestFileSize(t *testing.T) {
 	}
 	fmt.Println(fi.Size())
 }
+
+func TestReadAtCorrupt(t *testing.T) {
+	df, err := openDataFile(t.TempDir(), 0, true, defaultFileMode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer df.Close()
+
+	for _, k := range []string{"key1", "key2"} {
+		if _, err := df.Write(newDataEntry([]byte(k), []byte("value"), markPut)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	n, _, err := df.ReadAt(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// flip the last value byte of the second entry
+	fd, err := os.OpenFile(df.f.Name(), os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd.Close()
+	if _, err := fd.WriteAt([]byte{'X'}, df.Size()-1); err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = df.ReadAt(n)
+	var cerr *CorruptEntryError
+	if !errors.As(err, &cerr) || !errors.Is(err, ErrCorruptEntry) {
+		t.Fatalf("expected CorruptEntryError, got %v", err)
+	}
+	if cerr.FileID != 0 || cerr.Offset != n {
+		t.Fatalf("expected corruption at datafile 0 offset %d, got %+v", n, cerr)
+	}
+	if _, _, err := df.ReadAt(df.Size()); err != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+}
+
+func TestReadEntry(t *testing.T) {
+	df, err := openDataFile(t.TempDir(), 0, true, defaultFileMode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer df.Close()
+
+	e := newDataEntry([]byte("key"), []byte("value"), markPut)
+	if _, err := df.Write(e); err != nil {
+		t.Fatal(err)
+	}
+	size := int64(e.Size())
+	re, err := df.readEntry(0, size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(re.key) != "key" || string(re.value) != "value" {
+		t.Fatalf("expected key value, got %s %s", re.key, re.value)
+	}
+	var cerr *CorruptEntryError
+	// the size doesn't match the entry
+	if _, err := df.readEntry(0, size-1); !errors.As(err, &cerr) {
+		t.Fatalf("expected CorruptEntryError, got %v", err)
+	}
+	if _, err := df.readEntry(0, size+1); err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+
+	fd, err := os.OpenFile(df.f.Name(), os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd.Close()
+	if _, err := fd.WriteAt([]byte{'X'}, size-1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := df.readEntry(0, size); !errors.As(err, &cerr) || cerr.Offset != 0 {
+		t.Fatalf("expected CorruptEntryError at offset 0, got %v", err)
+	}
+}
+
+func TestReadValue(t *testing.T) {
+	df, err := openDataFile(t.TempDir(), 0, true, defaultFileMode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer df.Close()
+
+	e := newDataEntry([]byte("key"), []byte("value"), markPut)
+	if _, err := df.Write(e); err != nil {
+		t.Fatal(err)
+	}
+	size := int64(e.Size())
+	for _, verify := range []bool{true, false} {
+		value, err := df.readValue(0, size, []byte("key"), false, verify)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(value) != "value" {
+			t.Fatalf("expected value, got %s", value)
+		}
+	}
+	// the crc is checked with the key being read
+	var cerr *CorruptEntryError
+	if _, err := df.readValue(0, size, []byte("kex"), false, true); !errors.As(err, &cerr) {
+		t.Fatalf("expected CorruptEntryError, got %v", err)
+	}
+
+	fd, err := os.OpenFile(df.f.Name(), os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd.Close()
+	if _, err := fd.WriteAt([]byte{'X'}, size-1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := df.readValue(0, size, []byte("key"), false, true); !errors.As(err, &cerr) {
+		t.Fatalf("expected CorruptEntryError, got %v", err)
+	}
+	// not verified
+	value, err := df.readValue(0, size, []byte("key"), false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(value) != "valuX" {
+		t.Fatalf("expected valuX, got %s", value)
+	}
+}
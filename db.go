@@ -1,238 +1,353 @@
-package main
+package bitcask
 
 import (
 	"errors"
+	"fmt"
 	"io"
 	"os"
 	"path"
 	"path/filepath"
-	"sort"
 	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
 )
 
 const (
-	defaultMaxFileSize = 1 << 30
-	defaultDir         = "/tmp/bitcask"
+	defaultDir = "/tmp/bitcask"
 )
 
 type item struct {
 	fileID      int64
 	entryOffset int64
+	// seq of the commit writing the entry, not persisted
+	seq uint64
+	// unix nano, 0 means never expire
+	expiry int64
+	// size of the entry in the datafile
+	size int64
+}
+
+// expired reports whether the item is expired at now
+func (it *item) expired(now int64) bool {
+	return it.expiry != 0 && it.expiry <= now
+}
+
+// putItem sets the item of key, the entry of the old item becomes dead
+func (db *Bitcask) putItem(key string, it *item) {
+	db.deleteItem(key)
+	db.index.Put(key, it)
+	if df, ok := db.datafiles[it.fileID]; ok {
+		df.live += it.size
+	}
+}
+
+// deleteItem deletes the item of key, its entry becomes dead
+func (db *Bitcask) deleteItem(key string) {
+	old, ok := db.index.Get(key)
+	if !ok {
+		return
+	}
+	db.index.Delete(key)
+	if df, ok := db.datafiles[old.fileID]; ok {
+		df.live -= old.size
+	}
 }
 
 type Bitcask struct {
-	index     map[string]*item
+	index     keydir
 	currID    int64
-	active    *DataFile
-	datafiles map[int64]*DataFile
-	hintfiles map[int64]*HintFile
+	active    *dataFile
+	datafiles map[int64]*dataFile
+	hintfiles map[int64]*hintFile
+	// datafiles removed by merge but pinned by iterators
+	retired   map[*dataFile]struct{}
 	dir       string
 	isMerging bool
+	merging   sync.WaitGroup
+	// held by the running folds, merge waits for them
+	foldMu sync.RWMutex
+	// sealed datafiles waiting for their hint files, guarded by mu,
+	// hintc wakes up hintLoop
+	hintq  []*dataFile
+	hintc  chan struct{}
+	closed bool
+	// closing is closed by Close to stop the background goroutines
+	closing chan struct{}
+	bg      sync.WaitGroup
+	// queue of group commit
+	writers []*writeRequest
+	wmu     sync.Mutex
+	wcond   *sync.Cond
+	// seq of the last commit
+	seq uint64
+	// number of open transactions, and the states of the keys changed
+	// while they are open, oldest first
+	txs      int
+	versions map[string][]version
+	// the expired entries at loadTime are not loaded
+	loadTime int64
+	// flock of the db dir, released by closing it, nil if read-only
+	lock *os.File
+	// serializes Refresh of the read-only db
+	refreshMu sync.Mutex
+	opts      *options
 	mu        sync.RWMutex
 }
 
-func Open(dir string) (*Bitcask, error) {
+// Open opens the bitcask db in dir, rebuilding the index from the
+// existing datafiles and hint files. defaultDir is used when dir is empty.
+func Open(dir string, opts ...Option) (*Bitcask, error) {
 	if dir == "" {
 		dir = defaultDir
 	}
-	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(o)
+	}
+	if err := o.validate(); err != nil {
 		return nil, err
 	}
-	db := &Bitcask{
-		currID:    -1,
-		index:     make(map[string]*item, 0),
-		datafiles: make(map[int64]*DataFile, 0),
-		hintfiles: make(map[int64]*HintFile, 0),
-		dir:       dir,
+	if path.Clean(o.mergePath(dir)) == path.Clean(dir) {
+		return nil, fmt.Errorf("%w: merge dir must not be the db dir", ErrInvalidOption)
 	}
+	return open(dir, o)
+}
 
-	db.loadDataFiles(db.dir)
-	db.loadHintFiles(db.dir)
-	db.loadIndex()
+func open(dir string, o *options) (*Bitcask, error) {
+	// a read-only db doesn't create the dir, and isn't locked so it can
+	// be opened with the writer
+	var lock *os.File
+	if o.readOnly {
+		if _, err := os.Stat(dir); err != nil {
+			return nil, err
+		}
+	} else {
+		if err := os.MkdirAll(dir, o.dirMode); err != nil {
+			return nil, err
+		}
+		var err error
+		if lock, err = lockDir(dir, o.fileMode); err != nil {
+			return nil, err
+		}
+	}
+	db := newBitcask(dir, o)
+	db.lock = lock
+
+	if err := db.load(); err != nil {
+		db.closeFiles()
+		return nil, err
+	}
+	if db.opts.readOnly {
+		db.setLastActive()
+		db.mmapSealed()
+		if db.opts.refreshInterval > 0 {
+			db.bg.Add(1)
+			go db.refreshLoop()
+		}
+		return db, nil
+	}
 	db.currID = db.nextID()
-	df, err := NewDataFile(db.dir, db.currID, true)
+	df, err := openDataFile(db.dir, db.currID, true, db.opts.fileMode)
 	if err != nil {
+		db.closeFiles()
 		return nil, err
 	}
 	db.active = df
 	db.datafiles[db.currID] = df
+	db.mmapSealed()
 
+	if db.opts.syncPolicy == SyncInterval {
+		db.bg.Add(1)
+		go db.syncLoop()
+	}
+	if db.opts.mergeInterval > 0 {
+		db.bg.Add(1)
+		go db.mergeLoop()
+	}
+	if db.opts.sealHint {
+		db.hintc = make(chan struct{}, 1)
+		// the datafiles sealed before a crash, or by an older version
+		for id, df := range db.datafiles {
+			if _, ok := db.hintfiles[id]; !ok && df != db.active {
+				db.queueHint(df)
+			}
+		}
+		db.bg.Add(1)
+		go db.hintLoop()
+	}
 	return db, nil
 }
 
+func newBitcask(dir string, o *options) *Bitcask {
+	db := &Bitcask{
+		currID:    -1,
+		index:     newKeydir(o.keydir),
+		datafiles: make(map[int64]*dataFile, 0),
+		hintfiles: make(map[int64]*hintFile, 0),
+		retired:   make(map[*dataFile]struct{}),
+		dir:       dir,
+		opts:      o,
+		closing:   make(chan struct{}),
+	}
+	db.wcond = sync.NewCond(&db.wmu)
+	return db
+}
+
+// Put adds or updates the value of key
 func (db *Bitcask) Put(key []byte, value []byte) error {
-	db.mu.Lock()
-	defer db.mu.Unlock()
+	return db.put(key, value, 0)
+}
+
+func (db *Bitcask) put(key []byte, value []byte, expiry int64) error {
 	// check key value size
-	offset, err := db.put(key, value)
-	if err != nil {
-		return err
+	if uint64(len(key)) > uint64(db.opts.maxKeySize) {
+		return ErrKeyTooLarge
 	}
-	it := &item{
-		fileID:      db.currID,
-		entryOffset: offset,
+	if uint64(len(value)) > db.opts.maxValueSize {
+		return ErrValueTooLarge
 	}
-	db.index[string(key)] = it
-	return nil
+	e := newDataEntry(key, value, markPut)
+	e.setExpiry(expiry)
+	return db.write(newWriteRequest(e))
 }
 
+// Get returns the value of key, or ErrKeyNotFound
 func (db *Bitcask) Get(key []byte) ([]byte, error) {
+	_, value, err := db.getItem(key, false)
+	return value, err
+}
+
+// getItem returns the unexpired item of key and its value. If owned, the
+// value is copied from the mapped memory of zero copy, so it stays valid
+// after the lock is released and the datafile is unmapped.
+func (db *Bitcask) getItem(key []byte, owned bool) (*item, []byte, error) {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
-	it, ok := db.index[string(key)]
-	if !ok {
-		return nil, errors.New("not found")
+	if db.closed {
+		return nil, nil, ErrClosed
+	}
+	it, ok := db.index.Get(string(key))
+	if !ok || it.expired(time.Now().UnixNano()) {
+		return nil, nil, ErrKeyNotFound
 	}
 	df, ok := db.datafiles[it.fileID]
 	if !ok {
-		return nil, errors.New("")
+		return nil, nil, ErrDataFileNotFound
 	}
-	e, err := db.get(df, it.entryOffset)
+	value, err := db.getValue(df, key, it)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	return e.value, nil
+	if owned && df.zeroCopy {
+		value = append([]byte(nil), value...)
+	}
+	return it, value, nil
 }
 
+// Del deletes key, or returns ErrKeyNotFound
 func (db *Bitcask) Del(key []byte) error {
-	db.mu.Lock()
-	defer db.mu.Unlock()
-	_, ok := db.index[string(key)]
-	// key not found
-	if !ok {
-		return errors.New("")
-	}
-	if err := db.del(key); err != nil {
-		return err
+	req := newWriteRequest(newDataEntry(key, nil, markDel))
+	req.check = func(lookup lookupFunc) error {
+		// key not found
+		if it, _ := lookup(string(key)); it == nil || it.expired(time.Now().UnixNano()) {
+			return ErrKeyNotFound
+		}
+		return nil
 	}
-	delete(db.index, string(key))
-	return nil
+	return db.write(req)
 }
 
+// Keys returns the number of keys in the db, expired keys are not counted
 func (db *Bitcask) Keys() int {
-	return len(db.index)
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return 0
+	}
+	// expired items stay in the index until merge or the next access
+	now := time.Now().UnixNano()
+	n := 0
+	db.index.ForEach(func(k string, it *item) bool {
+		if !it.expired(now) {
+			n++
+		}
+		return true
+	})
+	return n
 }
 
-func (db *Bitcask) merge() error {
-	db.mu.Lock()
-	// merging
-	if db.isMerging {
-		return nil
-	}
-	db.isMerging = true
-	db.mu.Unlock()
-	// like copy-on-write
-	tmpdir := path.Join(defaultDir, "tmp_db")
-	// tmpdir no datafile, currid=0
-	mdb, err := Open(tmpdir)
-	if err != nil {
-		return err
-	}
-	// copy index
+// Close rejects new operations with ErrClosed, waits for the in-flight
+// operations and the running merge, then syncs the active datafile and
+// closes all datafiles and hint files.
+func (db *Bitcask) Close() error {
 	db.mu.Lock()
-	mdb.index = make(map[string]*item, len(db.index))
-	for k, v := range db.index {
-		mdb.index[k] = v
-	}
-	lastid := db.currID
-	// force to use new datafile
-	err = db.checkIfNeeded(0, true)
-	if err != nil {
+	if db.closed {
 		db.mu.Unlock()
-		return err
+		return ErrClosed
 	}
+	db.closed = true
 	db.mu.Unlock()
-	hf := NewHintFile()
-	// mdb rebuild datafile
-	for _, v := range mdb.index {
-		db.mu.RLock()
-		file := db.datafiles[v.fileID]
-		db.mu.RUnlock()
-		// 随机读
-		_, entry, err := file.ReadAt(v.entryOffset)
-		if err != nil {
-			return err
-		}
-		// 顺序append
-		if err := mdb.Put(entry.key, entry.value); err != nil {
-			return err
-		}
-		// write hint file, the same as datafile fileid
-		it := mdb.index[string(entry.key)]
-		// 顺序append
-		// ==> bufio write
-		if err := hf.WriteHint(mdb.dir, it.fileID, entry.key, it.entryOffset); err != nil {
-			return err
-		}
-	}
-	hf.Flush()
+
+	// merge and background goroutines take db.mu, so wait for them
+	// without holding the lock
+	close(db.closing)
+	db.bg.Wait()
+	db.merging.Wait()
 
 	db.mu.Lock()
 	defer db.mu.Unlock()
-	startID := db.currID + 1
-	deadKey := make([]string, 0)
-	for k := range mdb.index {
-		// means k-v deleted
-		if _, ok := db.index[k]; !ok {
-			deadKey = append(deadKey, k)
-			continue
-		}
-		// means k-v has newer value
-		if db.index[k].fileID > db.currID {
-			continue
-		}
-		// update origin db index
-		mdb.index[k].fileID = mdb.index[k].fileID + startID
-		db.index[k] = mdb.index[k]
+	var err error
+	if !db.opts.readOnly {
+		err = db.active.Sync()
 	}
-	// move hint file, don't need to open it
-	files, err := filepath.Glob(path.Join(mdb.dir, hintFilePattern))
-	if err != nil {
-		return err
+	if cerr := db.closeFiles(); err == nil {
+		err = cerr
 	}
-	for _, file := range files {
-		newfile := getNewFileName(tmpdir, db.dir, file, startID)
-		if err := os.Rename(file, newfile); err != nil {
-			return err
+	db.index = nil
+	return err
+}
+
+// closeFiles closes all datafiles and hint files and releases the lock
+// of the db dir, returns the first error
+func (db *Bitcask) closeFiles() error {
+	var firstErr error
+	// active datafile is in datafiles too
+	for _, df := range db.datafiles {
+		if err := df.Close(); err != nil && firstErr == nil {
+			firstErr = err
 		}
 	}
-	// move tmp-datafile to db dir
-	for _, file := range mdb.datafiles {
-		newfile := getNewFileName(tmpdir, db.dir, file.f.Name(), startID)
-		if err := os.Rename(file.f.Name(), newfile); err != nil {
-			return err
-		}
-		fileid := getFileID(newfile)
-		df, err := NewDataFile(db.dir, fileid, false)
-		if err != nil {
-			return err
+	for _, hf := range db.hintfiles {
+		if err := hf.Close(); err != nil && firstErr == nil {
+			firstErr = err
 		}
-		db.datafiles[fileid] = df
-	}
-	// remove old datafile
-	for _, v := range db.datafiles {
-		if v.fileID > lastid {
-			// update currID
-			if v.fileID > db.currID {
-				db.currID = v.fileID
-			}
-			continue
+	}
+	// the iterators pinning them can't read a closed db
+	obsolete := false
+	var removeErr error
+	for df := range db.retired {
+		obsolete = obsolete || !df.replaced
+		if err := removeDataFile(df); err != nil && removeErr == nil {
+			removeErr = err
 		}
-		delete(db.datafiles, v.fileID)
-		os.Remove(v.f.Name())
+		delete(db.retired, df)
 	}
-	// force to use new datafile
-	if err := db.checkIfNeeded(0, true); err != nil {
-		return err
+	// the manifest kept for the obsolete datafiles
+	if obsolete && removeErr == nil {
+		db.removeMergedManifest()
 	}
-	// remove dead key, duplicate delete
-	// when rebuild mdb, db can delete some k-v, so del operation may before hint file,
-	// to solve this, we duplicate delete key
-	for _, k := range deadKey {
-		db.del([]byte(k))
+	if removeErr != nil && firstErr == nil {
+		firstErr = removeErr
 	}
-	db.isMerging = false
-	return nil
+	if db.lock != nil {
+		if err := db.lock.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	db.datafiles = nil
+	db.hintfiles = nil
+	return firstErr
 }
 
 // force==true, means db must use new datafile
@@ -240,63 +355,75 @@ func (db *Bitcask) checkIfNeeded(add int64, force bool) error {
 	if !force {
 		size := db.active.Size()
 		// can add entry
-		if size+add < defaultMaxFileSize {
+		if size+add < db.opts.maxFileSize {
 			return nil
 		}
 	}
-	// open new datafile
-	// close active
+	return db.rotate(db.nextID())
+}
+
+// rotate seals the active datafile and opens the new active datafile id
+func (db *Bitcask) rotate(id int64) error {
+	// seal active, it is kept open for reading so the iterators
+	// pinning it can still read it
 	db.active.isActive = false
-	if err := db.active.Close(); err != nil {
-		return err
+	if db.opts.syncPolicy != SyncNever {
+		if err := db.active.Sync(); err != nil {
+			return err
+		}
 	}
 
-	oldID := db.active.fileID
-	db.currID = db.nextID()
-	active, err := NewDataFile(db.dir, db.currID, true)
+	// open new datafile
+	db.currID = id
+	active, err := openDataFile(db.dir, db.currID, true, db.opts.fileMode)
 	if err != nil {
 		return err
 	}
+	db.queueHint(db.active)
+	db.mmapFile(db.active)
 	db.active = active
 	db.datafiles[db.currID] = active
-
-	// reopen old file
-	old, err := NewDataFile(db.dir, oldID, false)
-	if err != nil {
-		return err
-	}
-	db.datafiles[oldID] = old
-
 	return nil
 }
 
-func (db *Bitcask) get(df *DataFile, offset int64) (*Entry, error) {
-	_, entry, err := df.ReadAt(offset)
-	return entry, err
+// mmapFile maps the sealed datafile if mmap is enabled, it is read with
+// pread if the mapping fails
+func (db *Bitcask) mmapFile(df *dataFile) {
+	if !db.opts.mmap {
+		return
+	}
+	if err := df.mmap(db.opts.zeroCopy); err != nil {
+		log.WithError(err).WithField("datafile", df.f.Name()).Warn("mmap datafile")
+	}
 }
 
-func (db *Bitcask) put(key []byte, value []byte) (int64, error) {
-	return db.append(key, value, PUT)
+// mmapSealed maps all datafiles but the active one
+func (db *Bitcask) mmapSealed() {
+	for _, df := range db.datafiles {
+		if df != db.active {
+			db.mmapFile(df)
+		}
+	}
 }
 
-func (db *Bitcask) del(key []byte) error {
-	_, err := db.append(key, nil, DEL)
-	return err
+// get reads the entry of it from df with a single read
+func (db *Bitcask) get(df *dataFile, it *item) (*dataEntry, error) {
+	return df.readEntry(it.entryOffset, it.size)
 }
 
-func (db *Bitcask) append(key []byte, value []byte, mark uint8) (int64, error) {
-	if !db.active.isActive {
-		return 0, errors.New("")
-	}
-	e := NewEntry(key, value, mark)
-	if err := db.checkIfNeeded(int64(e.Size()), false); err != nil {
-		return 0, err
-	}
-	offset, err := db.active.Write(e)
+// getValue reads the value of key from df by the read policy
+func (db *Bitcask) getValue(df *dataFile, key []byte, it *item) ([]byte, error) {
+	switch db.opts.readPolicy {
+	case ReadValue:
+		return df.readValue(it.entryOffset, it.size, key, it.expiry != 0, true)
+	case ReadValueUnchecked:
+		return df.readValue(it.entryOffset, it.size, key, it.expiry != 0, false)
+	}
+	e, err := db.get(df, it)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	return offset, nil
+	return e.value, nil
 }
 
 func (db *Bitcask) loadDataFiles(dir string) error {
@@ -305,11 +432,11 @@ func (db *Bitcask) loadDataFiles(dir string) error {
 		return err
 	}
 	if db.datafiles == nil {
-		db.datafiles = make(map[int64]*DataFile)
+		db.datafiles = make(map[int64]*dataFile)
 	}
 	for _, file := range files {
 		id := getFileID(file)
-		df, err := NewDataFile(db.dir, id, false)
+		df, err := openDataFile(db.dir, id, false, db.opts.fileMode)
 		if err != nil {
 			return err
 		}
@@ -324,11 +451,16 @@ func (db *Bitcask) loadHintFiles(dir string) error {
 		return err
 	}
 	if db.hintfiles == nil {
-		db.hintfiles = make(map[int64]*HintFile)
+		db.hintfiles = make(map[int64]*hintFile)
 	}
 	for _, file := range files {
 		id := getFileID(file)
-		hf, err := OpenHintFile(dir, id)
+		hf, err := openHintFile(dir, id)
+		if errors.Is(err, ErrInvalidHintFile) {
+			// written by an older version, the datafile is read instead
+			log.WithError(err).Warn("ignore hint file")
+			continue
+		}
 		if err != nil {
 			return err
 		}
@@ -337,77 +469,125 @@ func (db *Bitcask) loadHintFiles(dir string) error {
 	return nil
 }
 
-// rebuild index
-func (db *Bitcask) loadIndex() {
-	dfs := make([]int64, 0)
-	for k := range db.datafiles {
-		dfs = append(dfs, k)
+func (db *Bitcask) load() error {
+	db.loadTime = time.Now().UnixNano()
+	if err := db.loadDataFiles(db.dir); err != nil {
+		return err
 	}
-	sort.Slice(dfs, func(i, j int) bool {
-		return dfs[i] < dfs[j]
-	})
-	for _, fid := range dfs {
-		// load from hint first
-		if hf, ok := db.hintfiles[fid]; ok {
-			db.loadIndexFromHint(hf)
-		} else {
-			df := db.datafiles[fid]
-			db.loadIndexFromFile(df)
-		}
+	if err := db.loadHintFiles(db.dir); err != nil {
+		return err
+	}
+	if err := db.recoverMerge(); err != nil {
+		return err
 	}
+	return db.loadIndex()
 }
 
-func (db *Bitcask) loadIndexFromHint(hf *HintFile) {
+func (db *Bitcask) loadIndexFromHint(p *partialIndex, hf *hintFile) error {
 	if hf == nil {
-		return
+		return nil
 	}
-	var offset int64 = 0
+	var offset int64 = hintHeaderLen
 	for {
 		n, he, err := hf.ReadAt(offset)
 		if err == io.EOF {
 			break
 		}
-		if err != nil || he == nil {
-			return
+		if err != nil {
+			return err
+		}
+		offset += n
+		if he.mark == markDel {
+			p.tombstones += he.entrySize()
+			p.items[string(he.key)] = nil
+			continue
+		}
+		// expired, the older entries of the key are expired too
+		if he.expiry != 0 && he.expiry <= db.loadTime {
+			p.items[string(he.key)] = nil
+			continue
 		}
-		it := &item{
+		p.items[string(he.key)] = &item{
 			fileID:      hf.fileID,
 			entryOffset: int64(he.offset),
+			expiry:      he.expiry,
+			size:        he.entrySize(),
 		}
-		offset += n
-		db.index[string(he.key)] = it
 	}
+	return nil
 }
 
-func (db *Bitcask) loadIndexFromFile(df *DataFile) {
+// loadIndexFromFile loads the entries of df from offset from, tail means df
+// is the last datafile, which may have a torn tail
+func (db *Bitcask) loadIndexFromFile(p *partialIndex, df *dataFile, from int64, tail bool) error {
 	if df == nil {
-		return
+		return nil
 	}
-	var offset int64 = 0
+	offset := from
+	// entries of the uncommitted batch
+	var batch []*dataEntry
+	var batchOffsets []int64
 	for {
 		n, entry, err := df.ReadAt(offset)
 		// read finish
 		if err == io.EOF {
 			break
 		}
-		if err != nil || entry == nil {
-			return
-		}
-		// means k-v deleted. pass
-		if entry.mark == DEL {
-			if _, ok := db.index[string(entry.key)]; ok {
-				delete(db.index, string(entry.key))
+		if err != nil {
+			skip, err := db.recoverEntry(df, offset, n, tail, err)
+			if err != nil {
+				return err
 			}
-			offset += n
+			// stop reading the datafile
+			if skip == 0 {
+				break
+			}
+			offset += skip
 			continue
 		}
-		it := &item{
-			fileID:      df.fileID,
-			entryOffset: offset,
+		switch {
+		case entry.mark&markBatch != 0:
+			batch = append(batch, entry)
+			batchOffsets = append(batchOffsets, offset)
+		case entry.mark == markCommit:
+			// apply the batch only if all its entries are read
+			if len(batch) == int(entry.batchCount()) {
+				for i, e := range batch {
+					db.loadEntry(p, df.fileID, batchOffsets[i], e)
+				}
+			}
+			batch, batchOffsets = nil, nil
+		default:
+			batch, batchOffsets = nil, nil
+			db.loadEntry(p, df.fileID, offset, entry)
 		}
 		// read next k-v
 		offset += n
-		db.index[string(entry.key)] = it
+	}
+	// the batch is cut by a crash before its commit entry
+	if tail && len(batch) > 0 {
+		if err := db.truncateBatch(df, batchOffsets[0]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadEntry updates the partial index with the entry read at offset of datafile fileID
+func (db *Bitcask) loadEntry(p *partialIndex, fileID, offset int64, entry *dataEntry) {
+	if entry.op() == markDel {
+		p.tombstones += int64(entry.Size())
+	}
+	// means k-v deleted or expired
+	if entry.op() == markDel || entry.expired(db.loadTime) {
+		p.items[string(entry.key)] = nil
+		return
+	}
+	p.items[string(entry.key)] = &item{
+		fileID:      fileID,
+		entryOffset: offset,
+		expiry:      entry.expiry,
+		size:        int64(entry.Size()),
 	}
 }
 
@@ -1,6 +1,7 @@
-package main
+package bitcask
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	log "github.com/sirupsen/logrus"
@@ -37,8 +38,10 @@ func TestDB(t *testing.T) {
 	}
 	fmt.Println(string(val))
 
-	ndb, err := Open("")
-	_ = ndb
+	// the db dir is locked by db
+	if _, err := Open(""); !errors.Is(err, ErrDatabaseLocked) {
+		panic(fmt.Sprintf("expected ErrDatabaseLocked, got %v", err))
+	}
 }
 
 func TestGlob(t *testing.T) {
@@ -52,10 +55,6 @@ func TestGlob(t *testing.T) {
 }
 
 func TestDBload(t *testing.T) {
-	db, err := Open("")
-	if err != nil {
-		panic(err)
-	}
 	fmt.Println(db.Keys())
 
 	val, err := db.Get([]byte("key"))
@@ -72,10 +71,6 @@ func TestDBload(t *testing.T) {
 }
 
 func TestMerge(t *testing.T) {
-	db, err := Open("")
-	if err != nil {
-		panic(err)
-	}
 	if err := db.Put([]byte("key"), []byte("value")); err != nil {
 		panic(err)
 	}
@@ -93,7 +88,7 @@ func TestMerge(t *testing.T) {
 	}
 	fmt.Println(string(val))
 
-	if err := db.merge(); err != nil {
+	if _, err := db.Merge(context.Background()); err != nil {
 		panic(err)
 	}
 	fmt.Println(db.Keys())
@@ -112,10 +107,6 @@ func TestNewFile(t *testing.T) {
 }
 
 func TestMergeDel(t *testing.T) {
-	db, err := Open("")
-	if err != nil {
-		panic(err)
-	}
 	if err := db.Put([]byte("key"), []byte("value")); err != nil {
 		panic(err)
 	}
@@ -129,7 +120,7 @@ func TestMergeDel(t *testing.T) {
 		panic(err)
 	}
 
-	if err := db.merge(); err != nil {
+	if _, err := db.Merge(context.Background()); err != nil {
 		panic(err)
 	}
 	fmt.Println(db.Keys())
@@ -143,18 +134,10 @@ func TestMergeDel(t *testing.T) {
 }
 
 func TestOnlyMerge(t *testing.T) {
-	db, err := Open("")
-	if err != nil {
-		panic(err)
-	}
-	db.merge()
+	db.Merge(context.Background())
 }
 
 func TestConcurrPut(t *testing.T) {
-	db, err := Open("")
-	if err != nil {
-		panic(err)
-	}
 	wg := sync.WaitGroup{}
 	wg.Add(2)
 	go func() {
@@ -172,20 +155,17 @@ func TestConcurrPut(t *testing.T) {
 	wg.Wait()
 
 	fmt.Println(db.Keys())
-	for k := range db.index {
+	db.index.ForEach(func(k string, _ *item) bool {
 		val, err := db.Get([]byte(k))
 		if err != nil {
 			panic(err)
 		}
 		fmt.Println(string(val))
-	}
+		return true
+	})
 }
 
 func TestPutMany(t *testing.T) {
-	db, err := Open("")
-	if err != nil {
-		panic(err)
-	}
 	for i := 0; i < 1000; i++ {
 		if err := db.Put([]byte(fmt.Sprintf("gor-%d-key-%d", 2, i)), []byte(fmt.Sprintf("gor-%d-value-%d", 2, i))); err != nil {
 			fmt.Println(err)
@@ -193,20 +173,17 @@ func TestPutMany(t *testing.T) {
 		}
 	}
 	fmt.Println(db.Keys())
-	for k := range db.index {
+	db.index.ForEach(func(k string, _ *item) bool {
 		val, err := db.Get([]byte(k))
 		if err != nil {
 			panic(err)
 		}
 		fmt.Println(string(val))
-	}
+		return true
+	})
 }
 
 func TestConcurrMer(t *testing.T) {
-	db, err := Open("")
-	if err != nil {
-		panic(err)
-	}
 	for i := 0; i < 10; i++ {
 		db.Put([]byte(fmt.Sprintf("gor-%d-key-%d", 1, i)), []byte(fmt.Sprintf("gor-%d-value-%d", 1, i)))
 	}
@@ -214,7 +191,7 @@ func TestConcurrMer(t *testing.T) {
 	wg.Add(2)
 	go func() {
 		defer wg.Done()
-		if err := db.merge(); err != nil {
+		if _, err := db.Merge(context.Background()); err != nil {
 			fmt.Println(err)
 			panic(err)
 		}
@@ -231,14 +208,15 @@ func TestConcurrMer(t *testing.T) {
 	}()
 	wg.Wait()
 	fmt.Println(db.Keys())
-	for k := range db.index {
+	db.index.ForEach(func(k string, _ *item) bool {
 		val, err := db.Get([]byte(k))
 		if err != nil {
 			panic(err)
 		}
 		_ = val
 		//fmt.Println(string(val))
-	}
+		return true
+	})
 }
 
 var db *Bitcask
@@ -255,6 +233,18 @@ func init() {
 	rand.Seed(time.Now().Unix())
 }
 
+// reopenDB closes db and opens it again, the db dir is locked while db is open
+func reopenDB() {
+	if err := db.Close(); err != nil {
+		panic(err)
+	}
+	d, err := Open("")
+	if err != nil {
+		panic(err)
+	}
+	db = d
+}
+
 func GetKey(n int) []byte {
 	return []byte("test_key_" + fmt.Sprintf("%09d", n))
 }
@@ -298,7 +288,7 @@ func BenchmarkGetKV(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		key := GetKey(i)
 		_, err := db.Get(key)
-		if errors.Is(err, errors.New("not found")) {
+		if errors.Is(err, ErrKeyNotFound) {
 			cnt++
 		}
 	}
@@ -342,11 +332,8 @@ func TestDel(t *testing.T) {
 	if err := db.Del([]byte("key")); err != nil {
 		panic(err)
 	}
-	ndb, err := Open("")
-	if err != nil {
-		panic(err)
-	}
-	fmt.Println(ndb.Keys())
+	reopenDB()
+	fmt.Println(db.Keys())
 }
 
 func TestHint(t *testing.T) {
@@ -382,7 +369,7 @@ func TestHint(t *testing.T) {
 	fmt.Println(db.Keys())
 
 	start = time.Now()
-	db.merge()
+	db.Merge(context.Background())
 	dur = time.Since(start)
 	log.WithFields(log.Fields{
 		"duration": dur,
@@ -391,16 +378,13 @@ func TestHint(t *testing.T) {
 	fmt.Println(db.Keys())
 
 	start = time.Now()
-	ndb, err := Open("")
+	reopenDB()
 	dur = time.Since(start)
 	log.WithFields(log.Fields{
 		"duration": dur,
 	}).Info("rebuild")
 
-	if err != nil {
-		panic(err)
-	}
-	fmt.Println(ndb.Keys())
+	fmt.Println(db.Keys())
 	pprof.WriteHeapProfile(memf)
 }
 
@@ -412,7 +396,7 @@ func TestConMergeDel(t *testing.T) {
 	time.Sleep(5 * time.Second)
 
 	go func() {
-		db.merge()
+		db.Merge(context.Background())
 	}()
 
 	time.Sleep(100 * time.Millisecond)
@@ -421,11 +405,8 @@ func TestConMergeDel(t *testing.T) {
 	fmt.Println("del finish")
 
 	time.Sleep(3 * time.Second)
-	tdb, err := Open("")
-	if err != nil {
-		panic(err)
-	}
-	fmt.Println(tdb.Keys())
+	reopenDB()
+	fmt.Println(db.Keys())
 }
 
 func TestDBReopen(t *testing.T) {
@@ -440,3 +421,160 @@ func TestDBReopen(t *testing.T) {
 func TestPageSize(t *testing.T) {
 	fmt.Println(os.Getpagesize())
 }
+
+func TestClose(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put([]byte("key"), []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put([]byte("key"), []byte("value")); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+	if _, err := db.Get([]byte("key")); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+	if err := db.Del([]byte("key")); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+	if _, err := db.Merge(context.Background()); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+	if err := db.Close(); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+
+	ndb, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ndb.Close()
+	val, err := ndb.Get([]byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(val) != "value" {
+		t.Fatalf("expected value, got %s", val)
+	}
+}
+
+func TestCloseWaitMerge(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 1000; i++ {
+		if err := db.Put(GetKey(i), GetValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := db.Merge(context.Background())
+		errCh <- err
+	}()
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errCh; err != nil && !errors.Is(err, ErrClosed) {
+		t.Fatal(err)
+	}
+
+	ndb, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ndb.Close()
+	if ndb.Keys() != 1000 {
+		t.Fatalf("expected 1000 keys, got %d", ndb.Keys())
+	}
+}
+
+func TestGetCorrupt(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put([]byte("key"), []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	fd, err := os.OpenFile(db.active.f.Name(), os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fd.WriteAt([]byte{'X'}, db.active.Size()-1); err != nil {
+		t.Fatal(err)
+	}
+	fd.Close()
+
+	_, err = db.Get([]byte("key"))
+	var cerr *CorruptEntryError
+	if !errors.As(err, &cerr) {
+		t.Fatalf("expected CorruptEntryError, got %v", err)
+	}
+	if cerr.FileID != db.active.fileID || cerr.Offset != 0 {
+		t.Fatalf("unexpected corruption location %+v", cerr)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// the corrupt entry is the tail of the last datafile, truncated on open
+	ndb, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ndb.Close()
+	if _, err := ndb.Get([]byte("key")); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+}
+
+func TestReadPolicy(t *testing.T) {
+	want := make(map[string]string)
+	for i := 0; i < 30; i++ {
+		want[string(GetKey(i))] = string(GetValue(i))
+	}
+	for _, policy := range []ReadPolicy{ReadFull, ReadValue, ReadValueUnchecked} {
+		for _, mmap := range []bool{false, true} {
+			db := openPut(t, t.TempDir(), 30, WithMaxFileSize(1000), WithReadPolicy(policy), WithMmap(mmap))
+			checkValues(t, db, want)
+			iter, err := db.Iterator()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for ; iter.Valid(); iter.Next() {
+				val, err := iter.Value()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(val) != want[string(iter.Key())] {
+					t.Fatalf("policy %d: expected %s, got %s", policy, want[string(iter.Key())], val)
+				}
+			}
+			iter.Close()
+			if err := db.View(func(tx *Tx) error {
+				val, err := tx.Get(GetKey(0))
+				if err != nil {
+					return err
+				}
+				if string(val) != string(GetValue(0)) {
+					t.Fatalf("policy %d: expected %s, got %s", policy, GetValue(0), val)
+				}
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if err := db.Close(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
@@ -1,4 +1,4 @@
-package main
+package bitcask
 
 import (
 	"encoding/binary"
@@ -11,23 +11,37 @@ const (
 	keySizeLen   = 4
 	valueSizeLen = 8
 	metaLen      = crcLen + keySizeLen + valueSizeLen + markLen
+	// the expiry follows the meta only if the mark has markExpiry
+	expiryLen = 8
 
-	DEL = 0x1
-	PUT = 0x2
+	markDel = 0x1
+	markPut = 0x2
+	// markCommit ends the entries of a batch, its value is the number of entries
+	markCommit = 0x3
+	// markBatch flags the entries written by a batch, they are applied
+	// only if followed by the markCommit entry of the batch
+	markBatch = 0x80
+	// markExpiry flags the entries with an expiry, so the entries without
+	// it keep the format of the datafiles written before ttl
+	markExpiry = 0x40
+
+	batchCountLen = 4
 )
 
-type Entry struct {
+type dataEntry struct {
 	crc       uint32
 	keySize   uint32
 	valueSize uint64 // lt math.MaxUint64 - 4 - 4 - 8 - math.MaxUint32
 	mark      uint8
-	key       []byte
-	value     []byte
+	// unix nano, 0 means never expire
+	expiry int64
+	key    []byte
+	value  []byte
 }
 
-func NewEntry(key, value []byte, mark uint8) *Entry {
+func newDataEntry(key, value []byte, mark uint8) *dataEntry {
 	// crc := crc32.ChecksumIEEE()
-	return &Entry{
+	return &dataEntry{
 		keySize:   uint32(len(key)),
 		valueSize: uint64(len(value)),
 		key:       key,
@@ -36,21 +50,67 @@ func NewEntry(key, value []byte, mark uint8) *Entry {
 	}
 }
 
-func (e *Entry) Size() uint64 {
-	return uint64(e.keySize) + e.valueSize + metaLen
+func newCommitEntry(count int) *dataEntry {
+	value := make([]byte, batchCountLen)
+	binary.BigEndian.PutUint32(value, uint32(count))
+	return newDataEntry(nil, value, markCommit)
+}
+
+// op returns the mark without the markBatch and markExpiry flags
+func (e *dataEntry) op() uint8 {
+	return e.mark &^ (markBatch | markExpiry)
+}
+
+// setExpiry sets the expiry of the entry, 0 means never expire
+func (e *dataEntry) setExpiry(expiry int64) {
+	e.expiry = expiry
+	if expiry != 0 {
+		e.mark |= markExpiry
+	} else {
+		e.mark &^= markExpiry
+	}
+}
+
+// metaSize returns the size of the meta, with the expiry if the entry has it
+func (e *dataEntry) metaSize() uint64 {
+	return entryMetaSize(e.mark)
+}
+
+// entryMetaSize returns the size of the meta of the entry with mark
+func entryMetaSize(mark uint8) uint64 {
+	if mark&markExpiry != 0 {
+		return metaLen + expiryLen
+	}
+	return metaLen
+}
+
+// batchCount returns the number of entries committed by the COMMIT entry
+func (e *dataEntry) batchCount() uint32 {
+	if len(e.value) != batchCountLen {
+		return 0
+	}
+	return binary.BigEndian.Uint32(e.value)
 }
 
-func (e *Entry) Encode() (uint64, []byte) {
+func (e *dataEntry) Size() uint64 {
+	return uint64(e.keySize) + e.valueSize + e.metaSize()
+}
+
+func (e *dataEntry) Encode() (uint64, []byte) {
 	entryBuf := make([]byte, e.Size())
+	meta := e.metaSize()
 
 	// meta info
 	entryBuf[crcLen] = byte(e.mark)
 	binary.BigEndian.PutUint32(entryBuf[crcLen+markLen:crcLen+markLen+keySizeLen], e.keySize)
 	binary.BigEndian.PutUint64(entryBuf[crcLen+markLen+keySizeLen:metaLen], e.valueSize)
+	if e.mark&markExpiry != 0 {
+		binary.BigEndian.PutUint64(entryBuf[metaLen:metaLen+expiryLen], uint64(e.expiry))
+	}
 
 	// k-v
-	copy(entryBuf[metaLen:metaLen+e.keySize], e.key)
-	copy(entryBuf[metaLen+e.keySize:], e.value)
+	copy(entryBuf[meta:meta+uint64(e.keySize)], e.key)
+	copy(entryBuf[meta+uint64(e.keySize):], e.value)
 
 	// crc32
 	e.crc = crc32.ChecksumIEEE(entryBuf[crcLen:])
@@ -59,29 +119,65 @@ func (e *Entry) Encode() (uint64, []byte) {
 	return e.Size(), entryBuf
 }
 
-func (e *Entry) DecodeMeta(data []byte) {
+// DecodeMeta decodes the meta of metaLen bytes, the expiry is decoded by
+// decodeExpiry if the mark has markExpiry
+func (e *dataEntry) DecodeMeta(data []byte) {
 	e.crc = binary.BigEndian.Uint32(data[:crcLen])
 	e.mark = uint8(data[crcLen])
 	e.keySize = binary.BigEndian.Uint32(data[crcLen+markLen : crcLen+markLen+keySizeLen])
 	e.valueSize = binary.BigEndian.Uint64(data[crcLen+markLen+keySizeLen : metaLen])
 }
 
-func (e *Entry) DecodeKV(data []byte) {
+// decodeExpiry decodes the expiry following the meta in data
+func (e *dataEntry) decodeExpiry(data []byte) {
+	e.expiry = int64(binary.BigEndian.Uint64(data[metaLen : metaLen+expiryLen]))
+}
+
+// expired reports whether the entry is expired at now
+func (e *dataEntry) expired(now int64) bool {
+	return e.expiry != 0 && e.expiry <= now
+}
+
+// checkCRC verifies the crc decoded from meta against the meta and k-v
+// bytes, the k-v may be split into the key and the value. meta includes
+// the expiry if the entry has it.
+func (e *dataEntry) checkCRC(meta []byte, kv ...[]byte) bool {
+	crc := crc32.ChecksumIEEE(meta[crcLen:e.metaSize()])
+	for _, b := range kv {
+		crc = crc32.Update(crc, crc32.IEEETable, b)
+	}
+	return crc == e.crc
+}
+
+func (e *dataEntry) DecodeKV(data []byte) {
 	e.key = make([]byte, e.keySize)
 	e.value = make([]byte, e.valueSize)
 	copy(e.key, data[:e.keySize])
 	copy(e.value, data[e.keySize:])
 }
 
-func Decode(data []byte) *Entry {
-	e := &Entry{}
-	e.crc = binary.BigEndian.Uint32(data[:crcLen])
-	e.mark = uint8(data[crcLen])
-	e.keySize = binary.BigEndian.Uint32(data[crcLen+markLen : crcLen+markLen+keySizeLen])
-	e.valueSize = binary.BigEndian.Uint64(data[crcLen+markLen+keySizeLen : metaLen])
-	e.key = make([]byte, e.keySize)
-	e.value = make([]byte, e.valueSize)
-	copy(e.key, data[metaLen:metaLen+e.keySize])
-	copy(e.value, data[metaLen+e.keySize:])
-	return e
+// decodeKVView sets the key and value of the entry to the slices of data,
+// without copying
+func (e *dataEntry) decodeKVView(data []byte) {
+	e.key = data[:e.keySize:e.keySize]
+	e.value = data[e.keySize:]
+}
+
+// decodeEntry decodes an encoded entry, returns ErrCorruptEntry if data is
+// truncated or the crc mismatches
+func decodeEntry(data []byte) (*dataEntry, error) {
+	if len(data) < metaLen {
+		return nil, ErrCorruptEntry
+	}
+	e := &dataEntry{}
+	e.DecodeMeta(data)
+	meta := e.metaSize()
+	if uint64(len(data)) != e.Size() || !e.checkCRC(data[:meta], data[meta:]) {
+		return nil, ErrCorruptEntry
+	}
+	if e.mark&markExpiry != 0 {
+		e.decodeExpiry(data)
+	}
+	e.DecodeKV(data[meta:])
+	return e, nil
 }
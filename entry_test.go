@@ -1,23 +1,39 @@
-package main
+package bitcask
 
 import (
+	"errors"
 	"fmt"
 	"testing"
 )
 
 func TestEntry(t *testing.T) {
-	e := NewEntry([]byte("key"), []byte("value"), PUT)
+	e := newDataEntry([]byte("key"), []byte("value"), markPut)
 	_, bs := e.Encode()
 	fmt.Println(e.crc)
-	re := Decode(bs)
+	re, err := decodeEntry(bs)
+	if err != nil {
+		panic(err)
+	}
 	fmt.Println(*re)
 	fmt.Println(string(re.key), string(re.value))
 }
 
 func TestMeta(t *testing.T) {
-	e := NewEntry([]byte("key"), []byte("value"), PUT)
+	e := newDataEntry([]byte("key"), []byte("value"), markPut)
 	_, bs := e.Encode()
-	re := &Entry{}
+	re := &dataEntry{}
 	re.DecodeMeta(bs)
 	fmt.Println(*re)
 }
+
+func TestDecodeCorrupt(t *testing.T) {
+	e := newDataEntry([]byte("key"), []byte("value"), markPut)
+	_, bs := e.Encode()
+	bs[len(bs)-1] ^= 0x1
+	if _, err := decodeEntry(bs); !errors.Is(err, ErrCorruptEntry) {
+		t.Fatalf("expected ErrCorruptEntry, got %v", err)
+	}
+	if _, err := decodeEntry(bs[:metaLen-1]); !errors.Is(err, ErrCorruptEntry) {
+		t.Fatalf("expected ErrCorruptEntry, got %v", err)
+	}
+}
@@ -0,0 +1,67 @@
+package bitcask
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrInvalidOption is returned by Open when an option is invalid
+	ErrInvalidOption = errors.New("bitcask: invalid option")
+	// ErrKeyTooLarge is returned when the key is larger than the max key size
+	ErrKeyTooLarge = errors.New("bitcask: key too large")
+	// ErrValueTooLarge is returned when the value is larger than the max value size
+	ErrValueTooLarge = errors.New("bitcask: value too large")
+	// ErrClosed is returned when using a closed db
+	ErrClosed = errors.New("bitcask: db closed")
+	// ErrInvalidTTL is returned when the ttl is not positive
+	ErrInvalidTTL = errors.New("bitcask: invalid ttl")
+	// ErrKeyNotFound is returned when the key is not in the index
+	ErrKeyNotFound = errors.New("bitcask: key not found")
+	// ErrDatabaseLocked is returned by Open when the db dir is locked by
+	// another db, in this process or another one
+	ErrDatabaseLocked = errors.New("bitcask: database locked")
+	// ErrReadOnly is returned when writing or merging a read-only db
+	ErrReadOnly = errors.New("bitcask: read-only db")
+	// ErrMergeInProgress is returned by Merge when another merge is running
+	ErrMergeInProgress = errors.New("bitcask: merge in progress")
+	// ErrIteratorClosed is returned when using a closed iterator
+	ErrIteratorClosed = errors.New("bitcask: iterator closed")
+	// ErrDataFileNotFound is returned when the index points to a datafile that is not opened
+	ErrDataFileNotFound = errors.New("bitcask: datafile not found")
+	// ErrDataFileClosed is returned when reading or writing a closed datafile
+	ErrDataFileClosed = errors.New("bitcask: datafile closed")
+	// ErrDataFileNotActive is returned when writing a sealed datafile
+	ErrDataFileNotActive = errors.New("bitcask: datafile is not active")
+	// ErrDataFileOverflow is returned when an entry makes the datafile offset overflow
+	ErrDataFileOverflow = errors.New("bitcask: datafile offset overflow")
+	// ErrConflict is returned when a transaction reads or commits a key
+	// changed by another commit after the transaction read it
+	ErrConflict = errors.New("bitcask: transaction conflict")
+	// ErrTxDone is returned when using a transaction after it ends
+	ErrTxDone = errors.New("bitcask: transaction done")
+	// ErrTxReadOnly is returned when writing in a View transaction
+	ErrTxReadOnly = errors.New("bitcask: read-only transaction")
+	// ErrCorruptEntry is returned when an entry fails the crc check,
+	// use errors.As with *CorruptEntryError to get its location
+	ErrCorruptEntry = errors.New("bitcask: corrupt entry")
+	// ErrHintFileNil is returned when writing a nil hint file
+	ErrHintFileNil = errors.New("bitcask: nil hint file")
+	// ErrInvalidHintFile is returned when opening a hint file without a
+	// known header, Open reads its datafile instead
+	ErrInvalidHintFile = errors.New("bitcask: invalid hint file")
+)
+
+// CorruptEntryError is the location of an entry failing the crc check
+type CorruptEntryError struct {
+	FileID int64
+	Offset int64
+}
+
+func (e *CorruptEntryError) Error() string {
+	return fmt.Sprintf("%v: datafile %d offset %d", ErrCorruptEntry, e.FileID, e.Offset)
+}
+
+func (e *CorruptEntryError) Unwrap() error {
+	return ErrCorruptEntry
+}
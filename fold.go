@@ -0,0 +1,117 @@
+package bitcask
+
+import (
+	"errors"
+	"io"
+	"sort"
+	"time"
+)
+
+// Fold calls fn with every live key and its value, and stops at the first
+// error returned by fn. The datafiles are read sequentially in fileID
+// order, and an entry is live if the index still points to it when it is
+// read, so no key list is built. The keys unchanged during Fold are
+// visited once; the keys written during Fold are visited at most once.
+// Merge waits for the running folds, so fn must not call Merge.
+func (db *Bitcask) Fold(fn func(key, value []byte) error) error {
+	return db.fold(func(e *dataEntry) error {
+		return fn(e.key, e.value)
+	})
+}
+
+// ForEachKey calls fn with every live key like Fold
+func (db *Bitcask) ForEachKey(fn func(key []byte) error) error {
+	return db.fold(func(e *dataEntry) error {
+		return fn(e.key)
+	})
+}
+
+func (db *Bitcask) fold(fn func(e *dataEntry) error) error {
+	// merge moves the entries to other datafiles
+	db.foldMu.RLock()
+	defer db.foldMu.RUnlock()
+
+	db.mu.RLock()
+	if db.closed {
+		db.mu.RUnlock()
+		return ErrClosed
+	}
+	ids := make([]int64, 0, len(db.datafiles))
+	for id := range db.datafiles {
+		ids = append(ids, id)
+	}
+	// the entries written after the fold begins are not read
+	lastID, lastSize := db.currID, int64(0)
+	// a read-only db may have no datafile
+	if db.active != nil {
+		lastSize = db.active.Size()
+	}
+	db.mu.RUnlock()
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+
+	for _, id := range ids {
+		if id > lastID {
+			break
+		}
+		limit := int64(-1)
+		if id == lastID {
+			limit = lastSize
+		}
+		var offset int64
+		for {
+			n, e, err := db.foldEntry(id, offset, limit)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			offset += n
+			if e == nil {
+				continue
+			}
+			if err := fn(e); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// foldEntry reads the entry at offset of datafile id, and returns it if it
+// is live, nil otherwise. The datafile is read until limit, or its size if
+// limit is -1.
+func (db *Bitcask) foldEntry(id, offset, limit int64) (int64, *dataEntry, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return 0, nil, ErrClosed
+	}
+	df, ok := db.datafiles[id]
+	if !ok {
+		return 0, nil, ErrDataFileNotFound
+	}
+	if limit >= 0 && offset >= limit {
+		return 0, nil, io.EOF
+	}
+	n, e, err := df.ReadAt(offset)
+	if err != nil {
+		// Open skipped the corrupt entries, they are not in the index
+		if db.opts.skipCorrupt {
+			if errors.Is(err, ErrCorruptEntry) && n > 0 {
+				return n, nil, nil
+			}
+			if err == io.ErrUnexpectedEOF {
+				return 0, nil, io.EOF
+			}
+		}
+		return 0, nil, err
+	}
+	it, ok := db.index.Get(string(e.key))
+	if !ok || it.fileID != id || it.entryOffset != offset || it.expired(time.Now().UnixNano()) {
+		return n, nil, nil
+	}
+	return n, e, nil
+}
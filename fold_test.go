@@ -0,0 +1,147 @@
+package bitcask
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestFold(t *testing.T) {
+	dir := t.TempDir()
+	db := openPut(t, dir, 20, WithMaxFileSize(256))
+	defer db.Close()
+
+	// overwritten, deleted and expired keys are not folded
+	if err := db.Put(GetKey(0), []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Del(GetKey(1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutWithTTL(GetKey(2), GetValue(2), 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	b := NewBatch()
+	b.Put(GetKey(3), []byte("batch"))
+	b.Delete(GetKey(4))
+	if err := db.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	want := map[string]string{
+		string(GetKey(0)): "new",
+		string(GetKey(3)): "batch",
+	}
+	for i := 5; i < 20; i++ {
+		want[string(GetKey(i))] = string(GetValue(i))
+	}
+	got := make(map[string]string)
+	err := db.Fold(func(key, value []byte) error {
+		if _, ok := got[string(key)]; ok {
+			t.Fatalf("key %s folded twice", key)
+		}
+		got[string(key)] = string(value)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d keys, got %d", len(want), len(got))
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Fatalf("expected %s of %s, got %s", v, k, got[k])
+		}
+	}
+
+	keys := make([]string, 0)
+	err = db.ForEachKey(func(key []byte) error {
+		keys = append(keys, string(key))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != len(want) {
+		t.Fatalf("expected %d keys, got %d", len(want), len(keys))
+	}
+	if sort.StringsAreSorted(keys) {
+		// GetKey(0) is rewritten in the last datafile
+		t.Fatalf("expected the keys in datafile order, got %v", keys)
+	}
+}
+
+func TestFoldStop(t *testing.T) {
+	db := openPut(t, t.TempDir(), 10)
+	defer db.Close()
+
+	stop := errors.New("stop")
+	n := 0
+	err := db.ForEachKey(func(key []byte) error {
+		n++
+		if n == 3 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop {
+		t.Fatalf("expected stop, got %v", err)
+	}
+	if n != 3 {
+		t.Fatalf("expected 3 keys, got %d", n)
+	}
+}
+
+func TestFoldWrite(t *testing.T) {
+	db := openPut(t, t.TempDir(), 10)
+	defer db.Close()
+
+	// the keys written by fn are not folded
+	n := 0
+	err := db.Fold(func(key, value []byte) error {
+		n++
+		return db.Put(append(key, '-'), value)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 10 {
+		t.Fatalf("expected 10 keys, got %d", n)
+	}
+	if db.Keys() != 20 {
+		t.Fatalf("expected 20 keys, got %d", db.Keys())
+	}
+}
+
+func TestFoldMerge(t *testing.T) {
+	db := openPut(t, t.TempDir(), 10, WithMaxFileSize(256))
+	defer db.Close()
+
+	errCh := make(chan error, 1)
+	n := 0
+	err := db.ForEachKey(func(key []byte) error {
+		if n == 0 {
+			go func() {
+				_, err := db.Merge(context.Background())
+				errCh <- err
+			}()
+			// merge waits for the fold
+			time.Sleep(50 * time.Millisecond)
+		}
+		n++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 10 {
+		t.Fatalf("expected 10 keys, got %d", n)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatal(err)
+	}
+}
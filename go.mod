@@ -1,8 +1,9 @@
-module bitcask
+module github.com/Qraffa/bitcask
 
 go 1.16
 
 require (
+	github.com/google/btree v1.0.1
 	github.com/sirupsen/logrus v1.8.1
 	golang.org/x/exp v0.0.0-20211012155715-ffe10e552389
 )
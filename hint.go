@@ -1,10 +1,11 @@
-package main
+package bitcask
 
 import (
 	"bufio"
 	"encoding/binary"
-	"errors"
 	"fmt"
+	"hash/crc32"
+	"io"
 	"os"
 	"path"
 )
@@ -13,124 +14,243 @@ const (
 	hintFilePattern = "bitcask.hint.*"
 	hintFilePrefix  = "bitcask.hint.%d"
 
-	offsetLen = 8
+	offsetLen   = 8
+	hintMetaLen = crcLen + keySizeLen + valueSizeLen + offsetLen + expiryLen + markLen
+
+	// hintMagic and hintVersion start the hint file, a hint file without
+	// them is ignored and its datafile is read instead
+	hintMagic     = "BCHF"
+	hintVersion   = 2
+	hintHeaderLen = 5 // hintMagic, hintVersion
 )
 
-type HintEntry struct {
-	// keysize offset key mark
-	keySize uint32
-	offset  uint64
-	key     []byte
+type hintEntry struct {
+	// crc keysize valuesize offset expiry mark key
+	crc       uint32
+	keySize   uint32
+	valueSize uint64
+	offset    uint64
+	expiry    int64
+	// PUT or DEL
+	mark uint8
+	key  []byte
 }
 
-type HintFile struct {
-	f         *os.File
-	fileID    int64
-	offset    int64
+type hintFile struct {
+	f      *os.File
+	fileID int64
+	offset int64
+	// size of the hint file opened for reading
+	size      int64
 	bufWriter *bufio.Writer
+	perm      os.FileMode
 }
 
-func NewHintFile() *HintFile {
-	return &HintFile{}
+// perm is used when WriteHint creates the hint file
+func newHintFile(perm os.FileMode) *hintFile {
+	return &hintFile{perm: perm}
 }
 
-func OpenHintFile(dir string, fileID int64) (*HintFile, error) {
-	fd, err := os.OpenFile(path.Join(dir, fmt.Sprintf(hintFilePrefix, fileID)), os.O_RDONLY, os.ModePerm)
+// openHintFile opens the hint file fileID for reading, it returns
+// ErrInvalidHintFile if the file doesn't start with the header
+func openHintFile(dir string, fileID int64) (*hintFile, error) {
+	fd, err := os.OpenFile(path.Join(dir, fmt.Sprintf(hintFilePrefix, fileID)), os.O_RDONLY, 0)
 	if err != nil {
 		return nil, err
 	}
+	fi, err := fd.Stat()
+	if err != nil {
+		fd.Close()
+		return nil, err
+	}
+	header := make([]byte, hintHeaderLen)
+	if _, err := fd.ReadAt(header, 0); err != nil || string(header[:len(hintMagic)]) != hintMagic {
+		fd.Close()
+		return nil, fmt.Errorf("%w: hint file %d has no header", ErrInvalidHintFile, fileID)
+	}
+	if v := header[len(hintMagic)]; v != hintVersion {
+		fd.Close()
+		return nil, fmt.Errorf("%w: hint file %d version %d", ErrInvalidHintFile, fileID, v)
+	}
 
-	return &HintFile{
+	return &hintFile{
 		f:      fd,
 		fileID: fileID,
-		offset: 0,
+		offset: hintHeaderLen,
+		size:   fi.Size(),
 	}, nil
 }
 
-func (h *HintFile) WriteHint(dir string, fileID int64, key []byte, offset int64) error {
+func (h *hintFile) WriteHint(dir string, fileID int64, key []byte, valueSize uint64, offset int64, expiry int64) error {
+	return h.write(dir, fileID, newHintEntry(key, valueSize, offset, expiry, markPut))
+}
+
+// WriteTombstone writes the DEL entry of key at offset of datafile fileID,
+// so the older entries of key in other datafiles stay deleted
+func (h *hintFile) WriteTombstone(dir string, fileID int64, key []byte, offset int64) error {
+	return h.write(dir, fileID, newHintEntry(key, 0, offset, 0, markDel))
+}
+
+func (h *hintFile) write(dir string, fileID int64, entry *hintEntry) error {
 	if h == nil {
-		return errors.New("")
+		return ErrHintFileNil
 	}
 	// write new file
 	if h.f == nil || h.fileID != fileID {
 		if h.f != nil {
+			// the buffered hints belong to the previous hint file
+			if err := h.bufWriter.Flush(); err != nil {
+				return err
+			}
 			if err := h.f.Close(); err != nil {
 				return err
 			}
 		}
 
-		fd, err := os.OpenFile(path.Join(dir, fmt.Sprintf(hintFilePrefix, fileID)), os.O_CREATE|os.O_APPEND|os.O_RDWR, os.ModePerm)
+		fd, err := os.OpenFile(path.Join(dir, fmt.Sprintf(hintFilePrefix, fileID)), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, h.perm)
 		if err != nil {
 			return err
 		}
 		h.f = fd
 		h.fileID = fileID
 		h.bufWriter = bufio.NewWriterSize(fd, 4096)
+		h.bufWriter.WriteString(hintMagic)
+		h.bufWriter.WriteByte(hintVersion)
+		h.offset = hintHeaderLen
 	}
-	entry := newHintEntry(key, offset)
 	size, entryBuf := entry.Encode()
 	h.bufWriter.Write(entryBuf)
 	h.offset += int64(size)
 	return nil
 }
 
-func (h *HintFile) Flush() {
+func (h *hintFile) Close() error {
+	if h.f == nil {
+		return nil
+	}
+	return h.f.Close()
+}
+
+func (h *hintFile) Flush() {
 	if h.f == nil || h.bufWriter == nil {
 		return
 	}
 	h.bufWriter.Flush()
 }
 
-func (h *HintFile) ReadAt(offset int64) (int64, *HintEntry, error) {
+// Sync flushes the buffered hints and syncs the hint file to disk
+func (h *hintFile) Sync() error {
+	if h.f == nil || h.bufWriter == nil {
+		return nil
+	}
+	if err := h.bufWriter.Flush(); err != nil {
+		return err
+	}
+	return h.f.Sync()
+}
+
+// ReadAt reads the hint at offset and verifies its crc. It returns io.EOF
+// at the end of the hint file, and ErrInvalidHintFile if the hint is
+// truncated or the crc mismatches.
+func (h *hintFile) ReadAt(offset int64) (int64, *hintEntry, error) {
 	if h.f == nil {
+		return 0, nil, ErrDataFileClosed
+	}
+	if offset >= h.size {
+		return 0, nil, io.EOF
+	}
+	if offset+hintMetaLen > h.size {
+		return 0, nil, h.invalid(offset)
 	}
 
-	metaBuf := make([]byte, keySizeLen+offsetLen)
-	metaOffset, err := h.f.ReadAt(metaBuf, offset)
-	if err != nil {
+	metaBuf := make([]byte, hintMetaLen)
+	if _, err := h.f.ReadAt(metaBuf, offset); err != nil {
 		return 0, nil, err
 	}
-
-	he := &HintEntry{}
-	he.keySize = binary.BigEndian.Uint32(metaBuf[:keySizeLen])
-	he.offset = binary.BigEndian.Uint64(metaBuf[keySizeLen : keySizeLen+offsetLen])
+	he := &hintEntry{}
+	he.decodeMeta(metaBuf)
+	// don't trust the key size before the crc is checked
+	if int64(he.keySize) > h.size-offset-hintMetaLen {
+		return 0, nil, h.invalid(offset)
+	}
 
 	keyBuf := make([]byte, he.keySize)
-	keyOffset, err := h.f.ReadAt(keyBuf, offset+keySizeLen+offsetLen)
-	if err != nil {
+	if _, err := h.f.ReadAt(keyBuf, offset+hintMetaLen); err != nil {
 		return 0, nil, err
 	}
+	crc := crc32.ChecksumIEEE(metaBuf[crcLen:])
+	if crc32.Update(crc, crc32.IEEETable, keyBuf) != he.crc {
+		return 0, nil, h.invalid(offset)
+	}
 	he.key = keyBuf
 
-	return int64(metaOffset + keyOffset), he, nil
+	return int64(he.Size()), he, nil
+}
+
+func (h *hintFile) invalid(offset int64) error {
+	return fmt.Errorf("%w: hint file %d corrupt at offset %d", ErrInvalidHintFile, h.fileID, offset)
 }
 
-func newHintEntry(key []byte, offset int64) *HintEntry {
-	return &HintEntry{
-		keySize: uint32(len(key)),
-		offset:  uint64(offset),
-		key:     key,
+func newHintEntry(key []byte, valueSize uint64, offset int64, expiry int64, mark uint8) *hintEntry {
+	return &hintEntry{
+		keySize:   uint32(len(key)),
+		valueSize: valueSize,
+		offset:    uint64(offset),
+		expiry:    expiry,
+		mark:      mark,
+		key:       key,
 	}
 }
 
-func (h *HintEntry) Size() uint64 {
-	return uint64(keySizeLen + offsetLen + h.keySize)
+func (h *hintEntry) Size() uint64 {
+	return uint64(hintMetaLen + h.keySize)
 }
 
-func (h *HintEntry) Encode() (uint64, []byte) {
+func (h *hintEntry) Encode() (uint64, []byte) {
 	entryBuf := make([]byte, h.Size())
 
-	binary.BigEndian.PutUint32(entryBuf[:keySizeLen], h.keySize)
-	binary.BigEndian.PutUint64(entryBuf[keySizeLen:keySizeLen+offsetLen], h.offset)
+	n := crcLen
+	binary.BigEndian.PutUint32(entryBuf[n:n+keySizeLen], h.keySize)
+	n += keySizeLen
+	binary.BigEndian.PutUint64(entryBuf[n:n+valueSizeLen], h.valueSize)
+	n += valueSizeLen
+	binary.BigEndian.PutUint64(entryBuf[n:n+offsetLen], h.offset)
+	n += offsetLen
+	binary.BigEndian.PutUint64(entryBuf[n:n+expiryLen], uint64(h.expiry))
+	entryBuf[hintMetaLen-markLen] = h.mark
+
+	copy(entryBuf[hintMetaLen:], h.key)
 
-	copy(entryBuf[keySizeLen+offsetLen:], h.key)
+	// crc32
+	h.crc = crc32.ChecksumIEEE(entryBuf[crcLen:])
+	binary.BigEndian.PutUint32(entryBuf[:crcLen], h.crc)
 
 	return h.Size(), entryBuf
 }
 
-func (h *HintEntry) Decode(data []byte) {
-	h.keySize = binary.BigEndian.Uint32(data[:keySizeLen])
-	h.offset = binary.BigEndian.Uint64(data[keySizeLen : keySizeLen+offsetLen])
+func (h *hintEntry) decodeEntry(data []byte) {
+	h.decodeMeta(data)
+	copy(h.key, data[hintMetaLen:])
+}
+
+func (h *hintEntry) decodeMeta(data []byte) {
+	h.crc = binary.BigEndian.Uint32(data[:crcLen])
+	n := crcLen
+	h.keySize = binary.BigEndian.Uint32(data[n : n+keySizeLen])
+	n += keySizeLen
+	h.valueSize = binary.BigEndian.Uint64(data[n : n+valueSizeLen])
+	n += valueSizeLen
+	h.offset = binary.BigEndian.Uint64(data[n : n+offsetLen])
+	n += offsetLen
+	h.expiry = int64(binary.BigEndian.Uint64(data[n : n+expiryLen]))
+	h.mark = data[hintMetaLen-markLen]
+}
 
-	copy(h.key, data[keySizeLen+offsetLen:])
+// entrySize returns the size of the datafile entry of the hint
+func (h *hintEntry) entrySize() int64 {
+	var mark uint8
+	if h.expiry != 0 {
+		mark = markExpiry
+	}
+	return int64(entryMetaSize(mark) + uint64(h.keySize) + h.valueSize)
 }
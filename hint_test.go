@@ -0,0 +1,190 @@
+package bitcask
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestHintEntryEncode(t *testing.T) {
+	for _, he := range []*hintEntry{
+		newHintEntry([]byte("key"), 5, 100, 42, markPut),
+		newHintEntry([]byte("key"), 0, 200, 0, markDel),
+	} {
+		_, buf := he.Encode()
+		got := &hintEntry{}
+		got.decodeMeta(buf)
+		got.key = make([]byte, got.keySize)
+		got.decodeEntry(buf)
+		if got.keySize != he.keySize || got.valueSize != he.valueSize || got.offset != he.offset ||
+			got.expiry != he.expiry || got.mark != he.mark || string(got.key) != string(he.key) {
+			t.Fatalf("expected %+v, got %+v", he, got)
+		}
+	}
+}
+
+func TestHintTombstone(t *testing.T) {
+	dir := t.TempDir()
+	// 10 entries per datafile
+	db := openPut(t, dir, 10, WithMaxFileSize(900))
+	want := make(map[string]string)
+	for i := 0; i < 10; i++ {
+		want[string(GetKey(i))] = string(GetValue(i))
+	}
+	put := func(key, value []byte) {
+		if err := db.Put(key, value); err != nil {
+			t.Fatal(err)
+		}
+		want[string(key)] = string(value)
+	}
+	del := func(from, to int) {
+		for i := from; i < to; i++ {
+			if err := db.Del(GetKey(i)); err != nil {
+				t.Fatal(err)
+			}
+			delete(want, string(GetKey(i)))
+		}
+	}
+	reopen := func() {
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+		var err error
+		db, err = Open(dir, WithMaxFileSize(900))
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkValues(t, db, want)
+	}
+
+	// the tombstones of the first datafile are in the second one, which
+	// is rewritten alone
+	del(0, 2)
+	for i := 30; i < 38; i++ {
+		put(GetKey(i), GetValue(i))
+	}
+	it, _ := db.index.Get(string(GetKey(30)))
+	second := it.fileID
+	put(GetKey(99), make([]byte, 1000))
+	for i := 30; i < 38; i++ {
+		put(GetKey(i), []byte("new"))
+	}
+	stats, err := db.Merge(context.Background(), WithIncremental(0.5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.KeysRewritten != 0 || stats.BytesReclaimed == 0 {
+		t.Fatalf("expected only the tombstones rewritten, got %+v", stats)
+	}
+	reopen()
+	if _, ok := db.hintfiles[second]; !ok {
+		t.Fatal("expected the hint file of the rewritten datafile")
+	}
+
+	del(2, 4)
+	if _, err := db.Merge(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	del(4, 6)
+	reopen()
+	put(GetKey(6), []byte("new"))
+	if _, err := db.Merge(context.Background(), WithIncremental(0.1)); err != nil {
+		t.Fatal(err)
+	}
+	del(30, 32)
+	reopen()
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHintFileHeader(t *testing.T) {
+	dir := t.TempDir()
+	db := openPut(t, dir, 10)
+	if _, err := db.Merge(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a hint file without the header is ignored
+	for id := range db.datafiles {
+		name := path.Join(dir, fmt.Sprintf(hintFilePrefix, id))
+		if err := os.WriteFile(name, []byte("bad"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := openHintFile(dir, id); !errors.Is(err, ErrInvalidHintFile) {
+			t.Fatalf("expected ErrInvalidHintFile, got %v", err)
+		}
+	}
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	want := make(map[string]string)
+	for i := 0; i < 10; i++ {
+		want[string(GetKey(i))] = string(GetValue(i))
+	}
+	checkValues(t, db, want)
+}
+
+func TestHintCorrupt(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt func(name string) error
+	}{
+		{"truncate", func(name string) error {
+			fi, err := os.Stat(name)
+			if err != nil {
+				return err
+			}
+			return os.Truncate(name, fi.Size()-3)
+		}},
+		{"flip", func(name string) error {
+			fd, err := os.OpenFile(name, os.O_RDWR, 0)
+			if err != nil {
+				return err
+			}
+			defer fd.Close()
+			_, err = fd.WriteAt([]byte{0xff}, hintHeaderLen+hintMetaLen)
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			db := openPut(t, dir, 21, WithMaxFileSize(900))
+			want := make(map[string]string)
+			for i := 0; i < 21; i++ {
+				want[string(GetKey(i))] = string(GetValue(i))
+			}
+			it, _ := db.index.Get(string(GetKey(0)))
+			id := it.fileID
+			waitHint(t, dir, id)
+			if err := db.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if err := tt.corrupt(path.Join(dir, fmt.Sprintf(hintFilePrefix, id))); err != nil {
+				t.Fatal(err)
+			}
+
+			// the datafile is read instead of the corrupt hint file
+			db, err := Open(dir, WithMaxFileSize(900), WithSealHint(false))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := db.hintfiles[id]; ok {
+				t.Fatal("expected the corrupt hint file ignored")
+			}
+			checkValues(t, db, want)
+			if err := db.Close(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
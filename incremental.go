@@ -0,0 +1,265 @@
+package bitcask
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// rewrittenItem is a live entry copied by mergeFile, new is nil if the
+// entry expired and is rewritten as a tombstone
+type rewrittenItem struct {
+	key string
+	old *item
+	new *item
+}
+
+// mergeFiles rewrites the sealed datafiles whose dead bytes, not counting
+// the tombstones, reach ratio of their size
+func (db *Bitcask) mergeFiles(ctx context.Context, ratio float64) (*MergeStats, error) {
+	db.foldMu.Lock()
+	defer db.foldMu.Unlock()
+	start := time.Now()
+
+	db.mu.RLock()
+	ids := make([]int64, 0)
+	for id, df := range db.datafiles {
+		if df == db.active || df.Size() == 0 {
+			continue
+		}
+		dead := df.Size() - df.live - df.tombstones
+		if float64(dead) >= ratio*float64(df.Size()) {
+			ids = append(ids, id)
+		}
+	}
+	db.mu.RUnlock()
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+
+	tmpdir, err := db.mergeTempDir()
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpdir)
+	stats := &MergeStats{}
+	for _, id := range ids {
+		if err := db.mergeFile(ctx, tmpdir, id, stats); err != nil {
+			return nil, err
+		}
+	}
+	stats.Duration = time.Since(start)
+	return stats, nil
+}
+
+// mergeFile rewrites the live entries and the tombstones of the sealed
+// datafile id into tmpdir, and renames it over the datafile
+func (db *Bitcask) mergeFile(ctx context.Context, tmpdir string, id int64, stats *MergeStats) error {
+	db.mu.RLock()
+	df := db.datafiles[id]
+	db.mu.RUnlock()
+
+	out, err := openDataFile(tmpdir, id, true, db.opts.fileMode)
+	if err != nil {
+		return err
+	}
+	// closed before it is renamed, closing it again only returns an error
+	defer out.Close()
+	// the entries are hinted in the order of the datafile, so a key
+	// deleted and put again in it is loaded as put
+	hf := newHintFile(db.opts.fileMode)
+	defer hf.Close()
+	rewritten := make([]rewrittenItem, 0)
+	// the index items of the expired entries
+	expired := make([]rewrittenItem, 0)
+	var tombstones int64
+	now := time.Now().UnixNano()
+	var offset int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		select {
+		case <-db.closing:
+			return ErrClosed
+		default:
+		}
+		// df is sealed, it is read without the lock
+		n, e, err := df.ReadAt(offset)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// Open skipped the corrupt entries
+			if db.opts.skipCorrupt && errors.Is(err, ErrCorruptEntry) && n > 0 {
+				offset += n
+				continue
+			}
+			if db.opts.skipCorrupt && err == io.ErrUnexpectedEOF {
+				break
+			}
+			return err
+		}
+		entryOffset := offset
+		offset += n
+
+		// the batches of a sealed datafile are committed
+		if e.op() == markCommit {
+			continue
+		}
+		db.mu.RLock()
+		it, ok := db.index.Get(string(e.key))
+		db.mu.RUnlock()
+		current := ok && it.fileID == id && it.entryOffset == entryOffset
+		// an expired entry hides the older entries of the key in other
+		// datafiles, as a tombstone does
+		if e.op() == markDel || e.expired(now) {
+			del := newDataEntry(e.key, nil, markDel)
+			delOffset, err := out.Write(del)
+			if err != nil {
+				return err
+			}
+			if err := hf.WriteTombstone(tmpdir, id, e.key, delOffset); err != nil {
+				return err
+			}
+			tombstones += int64(del.Size())
+			if e.op() == markPut && current {
+				expired = append(expired, rewrittenItem{key: string(e.key), old: it})
+			}
+			continue
+		}
+		if !current || it.expired(now) {
+			continue
+		}
+		ne := newDataEntry(e.key, e.value, markPut)
+		ne.setExpiry(e.expiry)
+		newOffset, err := out.Write(ne)
+		if err != nil {
+			return err
+		}
+		if err := hf.WriteHint(tmpdir, id, e.key, ne.valueSize, newOffset, ne.expiry); err != nil {
+			return err
+		}
+		rewritten = append(rewritten, rewrittenItem{
+			key: string(e.key),
+			old: it,
+			new: &item{
+				fileID:      id,
+				entryOffset: newOffset,
+				seq:         it.seq,
+				expiry:      it.expiry,
+				size:        int64(ne.Size()),
+			},
+		})
+	}
+	if err := out.Sync(); err != nil {
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	// the hint file must not be renamed before its content is durable
+	if err := hf.Sync(); err != nil {
+		return err
+	}
+	if err := hf.Close(); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return ErrClosed
+	}
+	stats.BytesReclaimed += df.Size() - out.Size()
+	// the expired items point to the entries dropped from the datafile,
+	// the bytes of their entries are not live in the new datafile
+	for _, r := range expired {
+		if cur, ok := db.index.Get(r.key); ok && cur == r.old {
+			db.deleteItem(r.key)
+		}
+	}
+	if out.Size() == 0 {
+		// nothing left in the datafile
+		return db.dropDataFile(df)
+	}
+	ndf, err := db.replaceDataFile(tmpdir, df)
+	if err != nil {
+		return err
+	}
+	ndf.tombstones = tombstones
+	for _, r := range rewritten {
+		// the entries changed during merge are dead in the new datafile
+		if cur, ok := db.index.Get(r.key); ok && cur == r.old {
+			db.index.Put(r.key, r.new)
+			ndf.live += r.new.size
+		}
+	}
+	stats.KeysRewritten += len(rewritten)
+	return nil
+}
+
+// replaceDataFile renames the rewritten datafile of tmpdir and its hint
+// file over df, and returns the new datafile. The hint file of df is
+// removed first, so a crash leaves either datafile without a stale hint.
+func (db *Bitcask) replaceDataFile(tmpdir string, df *dataFile) (*dataFile, error) {
+	id := df.fileID
+	name := fmt.Sprintf(dataFilePrefix, id)
+	hintName := fmt.Sprintf(hintFilePrefix, id)
+	if err := db.removeHintFile(id); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(path.Join(tmpdir, name), path.Join(db.dir, name)); err != nil {
+		return nil, err
+	}
+	// without the hint file the index is rebuilt from the datafile
+	if err := os.Rename(path.Join(tmpdir, hintName), path.Join(db.dir, hintName)); err != nil {
+		log.WithError(err).WithField("fileID", id).Warn("move hint file of rewritten datafile")
+	}
+	if err := syncDir(db.dir); err != nil {
+		return nil, err
+	}
+	ndf, err := openDataFile(db.dir, id, false, db.opts.fileMode)
+	if err != nil {
+		// the old datafile is still read from its fd
+		return nil, err
+	}
+	df.replaced = true
+	if err := db.retire(df); err != nil {
+		log.WithError(err).WithField("fileID", id).Warn("close rewritten datafile")
+	}
+	db.datafiles[id] = ndf
+	db.mmapFile(ndf)
+	return ndf, nil
+}
+
+// dropDataFile removes df without entries left and its hint file
+func (db *Bitcask) dropDataFile(df *dataFile) error {
+	if err := db.removeHintFile(df.fileID); err != nil {
+		return err
+	}
+	return db.retire(df)
+}
+
+// removeHintFile closes and removes the hint file id if it exists
+func (db *Bitcask) removeHintFile(id int64) error {
+	if hf, ok := db.hintfiles[id]; ok {
+		hf.Close()
+		delete(db.hintfiles, id)
+	}
+	err := os.Remove(path.Join(db.dir, fmt.Sprintf(hintFilePrefix, id)))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
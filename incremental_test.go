@@ -0,0 +1,226 @@
+package bitcask
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestIncrementalMerge(t *testing.T) {
+	dir := t.TempDir()
+	// 10 entries per datafile
+	db := openPut(t, dir, 30, WithMaxFileSize(900))
+	it, _ := db.index.Get(string(GetKey(0)))
+	first := it.fileID
+	it, _ = db.index.Get(string(GetKey(15)))
+	healthy := db.datafiles[it.fileID]
+	healthySize := healthy.Size()
+
+	// fragment the first datafile only
+	want := make(map[string]string)
+	for i := 0; i < 30; i++ {
+		want[string(GetKey(i))] = string(GetValue(i))
+	}
+	for i := 0; i < 8; i++ {
+		if err := db.Put(GetKey(i), []byte("new")); err != nil {
+			t.Fatal(err)
+		}
+		want[string(GetKey(i))] = "new"
+	}
+	firstSize := db.datafiles[first].Size()
+
+	iter, err := db.Iterator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats, err := db.Merge(context.Background(), WithIncremental(0.5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.KeysRewritten != 2 {
+		t.Fatalf("expected 2 keys rewritten, got %d", stats.KeysRewritten)
+	}
+	if stats.BytesReclaimed <= 0 || db.datafiles[first].Size() != firstSize-stats.BytesReclaimed {
+		t.Fatalf("unexpected %d bytes reclaimed of datafile size %d", stats.BytesReclaimed, firstSize)
+	}
+	if db.datafiles[healthy.fileID] != healthy || healthy.Size() != healthySize {
+		t.Fatal("expected the healthy datafile untouched")
+	}
+	// the rewritten datafile is still read by the iterator
+	for ; iter.Valid(); iter.Next() {
+		val, err := iter.Value()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(val) != want[string(iter.Key())] {
+			t.Fatalf("expected %s, got %s", want[string(iter.Key())], val)
+		}
+	}
+	if err := iter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(db.datafiles[first].f.Name()); err != nil {
+		t.Fatal(err)
+	}
+
+	checkValues(t, db, want)
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err = Open(dir, WithMaxFileSize(900))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	checkValues(t, db, want)
+}
+
+func TestIncrementalMergeTombstone(t *testing.T) {
+	dir := t.TempDir()
+	db := openPut(t, dir, 10, WithMaxFileSize(900))
+	// the tombstones of the first datafile are in the second one
+	for i := 0; i < 5; i++ {
+		if err := db.Del(GetKey(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 10; i < 15; i++ {
+		if err := db.Put(GetKey(i), GetValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	it, _ := db.index.Get(string(GetKey(10)))
+	second := it.fileID
+	for i := 10; i < 15; i++ {
+		if err := db.Put(GetKey(i), []byte("new")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// seal the second datafile
+	if err := db.Put(GetKey(99), make([]byte, 1000)); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := db.Merge(context.Background(), WithIncremental(0.5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// half of the first datafile is deleted too
+	if stats.KeysRewritten != 10 {
+		t.Fatalf("expected 10 keys rewritten, got %d", stats.KeysRewritten)
+	}
+	tombstones := 5 * int64(newDataEntry(GetKey(0), nil, markDel).Size())
+	if got := db.datafiles[second].tombstones; got != tombstones {
+		t.Fatalf("expected %d tombstone bytes in the rewritten datafile, got %d", tombstones, got)
+	}
+
+	want := make(map[string]string)
+	for i := 5; i < 10; i++ {
+		want[string(GetKey(i))] = string(GetValue(i))
+	}
+	for i := 10; i < 15; i++ {
+		want[string(GetKey(i))] = "new"
+	}
+	want[string(GetKey(99))] = string(make([]byte, 1000))
+	checkValues(t, db, want)
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// the deleted keys are not back
+	db, err = Open(dir, WithMaxFileSize(900))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	checkValues(t, db, want)
+}
+
+func TestIncrementalMergeDeadFile(t *testing.T) {
+	dir := t.TempDir()
+	db := openPut(t, dir, 10, WithMaxFileSize(900))
+	defer db.Close()
+	it, _ := db.index.Get(string(GetKey(0)))
+	first := db.datafiles[it.fileID]
+	for i := 0; i < 10; i++ {
+		if err := db.Put(GetKey(i), GetValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.Merge(context.Background(), WithIncremental(1)); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := db.datafiles[first.fileID]; ok {
+		t.Fatal("expected the dead datafile removed")
+	}
+	if _, err := os.Stat(first.f.Name()); !os.IsNotExist(err) {
+		t.Fatalf("expected the dead datafile removed, got %v", err)
+	}
+}
+
+func TestIncrementalMergeInvalidRatio(t *testing.T) {
+	db := openPut(t, t.TempDir(), 1)
+	defer db.Close()
+	if _, err := db.Merge(context.Background(), WithIncremental(2)); !errors.Is(err, ErrInvalidOption) {
+		t.Fatalf("expected ErrInvalidOption, got %v", err)
+	}
+}
+
+func TestIncrementalMergeExpired(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, WithMaxFileSize(900))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the first datafile keeps the old value of key, and stays live
+	if err := db.Put([]byte("key"), []byte("v0")); err != nil {
+		t.Fatal(err)
+	}
+	for i, first := 0, db.currID; db.currID == first; i++ {
+		if err := db.Put(GetKey(i), GetValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the second datafile has the expired value of key, and is dead
+	if err := db.PutWithTTL([]byte("key"), []byte("v1"), 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	n := 100
+	for second := db.currID; db.currID == second; n++ {
+		if err := db.Put(GetKey(n), GetValue(n)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 100; i < n; i++ {
+		if err := db.Put(GetKey(i), []byte("new")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(60 * time.Millisecond)
+	if _, err := db.Merge(context.Background(), WithIncremental(0.3)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Get([]byte("key")); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = Open(dir, WithMaxFileSize(900))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Get([]byte("key")); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound after reopen, got %v", err)
+	}
+	if err := db.Put([]byte("key"), []byte("v2")); err != nil {
+		t.Fatal(err)
+	}
+	for id, df := range db.datafiles {
+		if df.live < 0 || df.live > df.Size() {
+			t.Fatalf("datafile %d: unexpected %d live bytes of size %d", id, df.live, df.Size())
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package bitcask
+
+import (
+	"os"
+	"sort"
+	"time"
+)
+
+// Iterator iterates the live keys of the snapshot of the db when it is
+// created, in ascending order of key. The values are read lazily from the
+// datafiles, which are pinned until Close so merge can't remove them.
+// An Iterator is not safe for concurrent use.
+type Iterator struct {
+	db     *Bitcask
+	keys   []string
+	items  []*item
+	files  map[int64]*dataFile
+	pos    int
+	closed bool
+}
+
+// Iterator returns an iterator positioned at the first key. It must be
+// closed to release the datafiles.
+func (db *Bitcask) Iterator() (*Iterator, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return nil, ErrClosed
+	}
+	iter := &Iterator{
+		db:    db,
+		keys:  make([]string, 0, db.index.Len()),
+		items: make([]*item, 0, db.index.Len()),
+		files: make(map[int64]*dataFile),
+	}
+	now := time.Now().UnixNano()
+	// the items are never modified once in the index, so keeping the
+	// pointers is a snapshot
+	db.index.Ascend("", "", func(key string, it *item) bool {
+		if it.expired(now) {
+			return true
+		}
+		iter.keys = append(iter.keys, key)
+		iter.items = append(iter.items, it)
+		if _, ok := iter.files[it.fileID]; !ok {
+			df := db.datafiles[it.fileID]
+			df.refs++
+			iter.files[it.fileID] = df
+		}
+		return true
+	})
+	return iter, nil
+}
+
+// Seek moves the iterator to the first key greater than or equal to key
+func (iter *Iterator) Seek(key []byte) {
+	k := string(key)
+	iter.pos = sort.SearchStrings(iter.keys, k)
+}
+
+// Rewind moves the iterator to the first key
+func (iter *Iterator) Rewind() {
+	iter.pos = 0
+}
+
+// Next moves the iterator to the next key
+func (iter *Iterator) Next() {
+	if iter.pos < len(iter.keys) {
+		iter.pos++
+	}
+}
+
+// Valid reports whether the iterator is positioned at a key
+func (iter *Iterator) Valid() bool {
+	return !iter.closed && iter.pos < len(iter.keys)
+}
+
+// Key returns the current key, nil if the iterator is not valid
+func (iter *Iterator) Key() []byte {
+	if !iter.Valid() {
+		return nil
+	}
+	return []byte(iter.keys[iter.pos])
+}
+
+// Value reads the value of the current key in the snapshot
+func (iter *Iterator) Value() ([]byte, error) {
+	if iter.closed {
+		return nil, ErrIteratorClosed
+	}
+	if !iter.Valid() {
+		return nil, ErrKeyNotFound
+	}
+	db := iter.db
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	// the datafiles are closed with the db
+	if db.closed {
+		return nil, ErrClosed
+	}
+	it := iter.items[iter.pos]
+	return db.getValue(iter.files[it.fileID], []byte(iter.keys[iter.pos]), it)
+}
+
+// Close releases the datafiles pinned by the iterator
+func (iter *Iterator) Close() error {
+	if iter.closed {
+		return ErrIteratorClosed
+	}
+	iter.closed = true
+	db := iter.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var firstErr error
+	for _, df := range iter.files {
+		if err := db.unpin(df); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	iter.files = nil
+	return firstErr
+}
+
+func (db *Bitcask) unpin(df *dataFile) error {
+	df.refs--
+	if df.refs > 0 || !df.retired {
+		return nil
+	}
+	delete(db.retired, df)
+	// the db closed the datafile
+	if db.closed {
+		return nil
+	}
+	if err := removeDataFile(df); err != nil {
+		return err
+	}
+	if !df.replaced {
+		db.removeMergedManifest()
+	}
+	return nil
+}
+
+// retire removes a datafile no longer in the index, once it is not pinned
+// by an iterator. db.mu must be held.
+func (db *Bitcask) retire(df *dataFile) error {
+	delete(db.datafiles, df.fileID)
+	if df.refs > 0 {
+		df.retired = true
+		db.retired[df] = struct{}{}
+		return nil
+	}
+	return removeDataFile(df)
+}
+
+func removeDataFile(df *dataFile) error {
+	if err := df.Close(); err != nil {
+		return err
+	}
+	// the name is the rewritten datafile
+	if df.replaced {
+		return nil
+	}
+	return os.Remove(df.f.Name())
+}
@@ -0,0 +1,177 @@
+package bitcask
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+)
+
+func iterKeys(t *testing.T, iter *Iterator) []string {
+	t.Helper()
+	keys := make([]string, 0)
+	for ; iter.Valid(); iter.Next() {
+		keys = append(keys, string(iter.Key()))
+	}
+	return keys
+}
+
+func TestIterator(t *testing.T) {
+	for _, kd := range keydirs {
+		t.Run(kd.name, func(t *testing.T) {
+			db := openPut(t, t.TempDir(), 10, WithKeydir(kd.t))
+			defer db.Close()
+
+			iter, err := db.Iterator()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer iter.Close()
+			for i := 0; i < 10; i++ {
+				if !iter.Valid() {
+					t.Fatalf("expected key %d", i)
+				}
+				if string(iter.Key()) != string(GetKey(i)) {
+					t.Fatalf("expected %s, got %s", GetKey(i), iter.Key())
+				}
+				val, err := iter.Value()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(val) != string(GetValue(i)) {
+					t.Fatalf("expected %s, got %s", GetValue(i), val)
+				}
+				iter.Next()
+			}
+			if iter.Valid() {
+				t.Fatalf("unexpected key %s", iter.Key())
+			}
+
+			iter.Seek(GetKey(7))
+			if keys := iterKeys(t, iter); len(keys) != 3 || keys[0] != string(GetKey(7)) {
+				t.Fatalf("unexpected keys after seek %v", keys)
+			}
+			iter.Seek([]byte("z"))
+			if iter.Valid() {
+				t.Fatalf("unexpected key %s", iter.Key())
+			}
+			iter.Rewind()
+			if string(iter.Key()) != string(GetKey(0)) {
+				t.Fatalf("expected %s, got %s", GetKey(0), iter.Key())
+			}
+		})
+	}
+}
+
+func TestIteratorSnapshot(t *testing.T) {
+	db := openPut(t, t.TempDir(), 10)
+	defer db.Close()
+
+	iter, err := db.Iterator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer iter.Close()
+	if err := db.Put(GetKey(0), []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Del(GetKey(1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put(GetKey(10), GetValue(10)); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; iter.Valid(); iter.Next() {
+		val, err := iter.Value()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(iter.Key()) != string(GetKey(i)) || string(val) != string(GetValue(i)) {
+			t.Fatalf("expected %s %s, got %s %s", GetKey(i), GetValue(i), iter.Key(), val)
+		}
+		i++
+	}
+}
+
+func TestIteratorMerge(t *testing.T) {
+	dir := t.TempDir()
+	// an entry per datafile
+	db := openPut(t, dir, 10, WithMaxFileSize(64))
+	defer db.Close()
+
+	iter, err := db.Iterator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	it, _ := db.index.Get(string(GetKey(0)))
+	name := db.datafiles[it.fileID].f.Name()
+	for i := 0; i < 10; i++ {
+		if err := db.Put(GetKey(i), []byte("new")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.Merge(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// the merged datafile is kept for the iterator
+	if _, err := os.Stat(name); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; iter.Valid(); iter.Next() {
+		val, err := iter.Value()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(val) != string(GetValue(i)) {
+			t.Fatalf("expected %s, got %s", GetValue(i), val)
+		}
+		i++
+	}
+	if err := iter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Fatalf("expected the merged datafile removed, got %v", err)
+	}
+	if err := iter.Close(); !errors.Is(err, ErrIteratorClosed) {
+		t.Fatalf("expected ErrIteratorClosed, got %v", err)
+	}
+}
+
+func TestIteratorClosedDB(t *testing.T) {
+	dir := t.TempDir()
+	db := openPut(t, dir, 10, WithMaxFileSize(64))
+	iter, err := db.Iterator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	it, _ := db.index.Get(string(GetKey(0)))
+	name := db.datafiles[it.fileID].f.Name()
+	if _, err := db.Merge(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := iter.Value(); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+	if err := iter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := iter.Value(); !errors.Is(err, ErrIteratorClosed) {
+		t.Fatalf("expected ErrIteratorClosed, got %v", err)
+	}
+	// the merged datafiles are removed by Close
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Fatalf("expected the merged datafile removed, got %v", err)
+	}
+	db, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if db.Keys() != 10 {
+		t.Fatalf("expected 10 keys, got %d", db.Keys())
+	}
+}
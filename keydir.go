@@ -0,0 +1,177 @@
+package bitcask
+
+import (
+	"sort"
+
+	"github.com/google/btree"
+)
+
+// KeydirType is the in-memory index implementation of the db
+type KeydirType int
+
+const (
+	// HashKeydir is a hash map, ordered scans sort the matching keys
+	HashKeydir KeydirType = iota
+	// BTreeKeydir is a B-tree, ordered scans walk the tree
+	BTreeKeydir
+)
+
+const btreeDegree = 32
+
+// keydir maps the keys to their items. It is not safe for concurrent
+// use, the db guards it with db.mu. fn must not modify the keydir.
+type keydir interface {
+	Get(key string) (*item, bool)
+	Put(key string, it *item)
+	Delete(key string)
+	Len() int
+	// ForEach calls fn for every key in no particular order until fn
+	// returns false
+	ForEach(fn func(key string, it *item) bool)
+	// Ascend calls fn for the keys in [start, end) in ascending order
+	// until fn returns false, empty end means no upper bound
+	Ascend(start, end string, fn func(key string, it *item) bool)
+	// Descend is Ascend in descending order
+	Descend(start, end string, fn func(key string, it *item) bool)
+}
+
+func newKeydir(t KeydirType) keydir {
+	if t == BTreeKeydir {
+		return &btreeKeydir{tree: btree.New(btreeDegree)}
+	}
+	return hashKeydir(make(map[string]*item))
+}
+
+// inRange reports whether key is in [start, end), empty end means no upper bound
+func inRange(key, start, end string) bool {
+	return key >= start && (end == "" || key < end)
+}
+
+type hashKeydir map[string]*item
+
+func (h hashKeydir) Get(key string) (*item, bool) {
+	it, ok := h[key]
+	return it, ok
+}
+
+func (h hashKeydir) Put(key string, it *item) {
+	h[key] = it
+}
+
+func (h hashKeydir) Delete(key string) {
+	delete(h, key)
+}
+
+func (h hashKeydir) Len() int {
+	return len(h)
+}
+
+func (h hashKeydir) ForEach(fn func(key string, it *item) bool) {
+	for k, v := range h {
+		if !fn(k, v) {
+			return
+		}
+	}
+}
+
+func (h hashKeydir) Ascend(start, end string, fn func(key string, it *item) bool) {
+	keys := h.sortedKeys(start, end)
+	for _, k := range keys {
+		if !fn(k, h[k]) {
+			return
+		}
+	}
+}
+
+func (h hashKeydir) Descend(start, end string, fn func(key string, it *item) bool) {
+	keys := h.sortedKeys(start, end)
+	for i := len(keys) - 1; i >= 0; i-- {
+		if !fn(keys[i], h[keys[i]]) {
+			return
+		}
+	}
+}
+
+// sortedKeys returns the sorted keys in [start, end), a hash map has no
+// order so every key is visited
+func (h hashKeydir) sortedKeys(start, end string) []string {
+	keys := make([]string, 0)
+	for k := range h {
+		if inRange(k, start, end) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+type btreeItem struct {
+	key string
+	it  *item
+}
+
+func (a *btreeItem) Less(b btree.Item) bool {
+	return a.key < b.(*btreeItem).key
+}
+
+type btreeKeydir struct {
+	tree *btree.BTree
+}
+
+func (b *btreeKeydir) Get(key string) (*item, bool) {
+	i := b.tree.Get(&btreeItem{key: key})
+	if i == nil {
+		return nil, false
+	}
+	return i.(*btreeItem).it, true
+}
+
+func (b *btreeKeydir) Put(key string, it *item) {
+	b.tree.ReplaceOrInsert(&btreeItem{key: key, it: it})
+}
+
+func (b *btreeKeydir) Delete(key string) {
+	b.tree.Delete(&btreeItem{key: key})
+}
+
+func (b *btreeKeydir) Len() int {
+	return b.tree.Len()
+}
+
+func (b *btreeKeydir) ForEach(fn func(key string, it *item) bool) {
+	b.tree.Ascend(func(i btree.Item) bool {
+		bi := i.(*btreeItem)
+		return fn(bi.key, bi.it)
+	})
+}
+
+func (b *btreeKeydir) Ascend(start, end string, fn func(key string, it *item) bool) {
+	iter := func(i btree.Item) bool {
+		bi := i.(*btreeItem)
+		return fn(bi.key, bi.it)
+	}
+	if end == "" {
+		b.tree.AscendGreaterOrEqual(&btreeItem{key: start}, iter)
+		return
+	}
+	b.tree.AscendRange(&btreeItem{key: start}, &btreeItem{key: end}, iter)
+}
+
+func (b *btreeKeydir) Descend(start, end string, fn func(key string, it *item) bool) {
+	iter := func(i btree.Item) bool {
+		bi := i.(*btreeItem)
+		if bi.key < start {
+			return false
+		}
+		// end is excluded
+		if end != "" && bi.key >= end {
+			return true
+		}
+		return fn(bi.key, bi.it)
+	}
+	if end == "" {
+		b.tree.Descend(iter)
+		return
+	}
+	b.tree.DescendLessOrEqual(&btreeItem{key: end}, iter)
+}
@@ -0,0 +1,120 @@
+package bitcask
+
+import (
+	"errors"
+	"sort"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// partialIndex is the index loaded from one datafile or its hint file,
+// a nil item is a deleted or expired key
+type partialIndex struct {
+	items map[string]*item
+	// bytes of the tombstones in the datafile
+	tombstones int64
+}
+
+func newPartialIndex() *partialIndex {
+	return &partialIndex{items: make(map[string]*item)}
+}
+
+type loadResult struct {
+	p *partialIndex
+	// the hint file is corrupt, the datafile was read instead
+	invalidHint bool
+	err         error
+}
+
+// rebuild index, the datafiles are loaded in parallel and applied to the
+// index in fileid order, so the later entries of a key win
+func (db *Bitcask) loadIndex() error {
+	dfs := make([]int64, 0)
+	for k := range db.datafiles {
+		dfs = append(dfs, k)
+	}
+	sort.Slice(dfs, func(i, j int) bool {
+		return dfs[i] < dfs[j]
+	})
+	results := make([]chan loadResult, len(dfs))
+	for i := range results {
+		results[i] = make(chan loadResult, 1)
+	}
+	// bounds the partial indexes loaded but not applied yet
+	sem := make(chan struct{}, db.opts.loadParallelism)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	// the datafiles are closed by Open on error, wait for the loaders
+	defer func() {
+		close(done)
+		wg.Wait()
+	}()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i, fid := range dfs {
+			select {
+			case sem <- struct{}{}:
+			case <-done:
+				return
+			}
+			wg.Add(1)
+			go func(i int, fid int64) {
+				defer wg.Done()
+				// the last datafile was the active one, may has a torn tail
+				results[i] <- db.loadPartial(fid, i == len(dfs)-1)
+			}(i, fid)
+		}
+	}()
+	invalid := make([]int64, 0)
+	for i, fid := range dfs {
+		r := <-results[i]
+		<-sem
+		if r.err != nil {
+			return r.err
+		}
+		if r.invalidHint {
+			invalid = append(invalid, fid)
+		}
+		db.applyPartial(fid, r.p)
+		if db.opts.loadProgress != nil {
+			db.opts.loadProgress(i+1, len(dfs))
+		}
+	}
+	// the hint files are written again for the sealed datafiles, they are
+	// removed after the loaders reading db.hintfiles are done
+	for _, fid := range invalid {
+		db.hintfiles[fid].Close()
+		delete(db.hintfiles, fid)
+	}
+	return nil
+}
+
+// loadPartial loads the partial index of datafile fid, from its hint file
+// first. A corrupt hint file is discarded and the datafile is read instead.
+func (db *Bitcask) loadPartial(fid int64, tail bool) loadResult {
+	r := loadResult{p: newPartialIndex()}
+	if hf, ok := db.hintfiles[fid]; ok {
+		r.err = db.loadIndexFromHint(r.p, hf)
+		if !errors.Is(r.err, ErrInvalidHintFile) {
+			return r
+		}
+		log.WithError(r.err).Warn("ignore hint file")
+		r = loadResult{p: newPartialIndex(), invalidHint: true}
+	}
+	r.err = db.loadIndexFromFile(r.p, db.datafiles[fid], 0, tail)
+	return r
+}
+
+// applyPartial applies the partial index of datafile fid to the index
+func (db *Bitcask) applyPartial(fid int64, p *partialIndex) {
+	db.datafiles[fid].tombstones += p.tombstones
+	for k, it := range p.items {
+		if it == nil {
+			db.deleteItem(k)
+			continue
+		}
+		db.putItem(k, it)
+	}
+}
@@ -0,0 +1,113 @@
+package bitcask
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParallelLoad(t *testing.T) {
+	dir := t.TempDir()
+	opts := []Option{WithMaxFileSize(1000), WithSealHint(false)}
+	db := openPut(t, dir, 100, opts...)
+	// overwrites, deletes and expired keys across the datafiles
+	for i := 0; i < 100; i += 3 {
+		if err := db.Put(GetKey(i), []byte("new")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 100; i += 5 {
+		if err := db.Del(GetKey(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 1; i < 100; i += 7 {
+		if err := db.PutWithTTL(GetKey(i), GetValue(i), time.Millisecond); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b := NewBatch()
+	b.Put(GetKey(0), []byte("batch"))
+	b.Delete(GetKey(3))
+	if err := db.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+
+	load := func(n int) (map[string]item, map[int64][2]int64) {
+		db, err := Open(dir, append(opts, WithLoadParallelism(n))...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		items := make(map[string]item)
+		db.index.ForEach(func(k string, it *item) bool {
+			items[k] = *it
+			return true
+		})
+		bytes := make(map[int64][2]int64)
+		for id, df := range db.datafiles {
+			bytes[id] = [2]int64{df.live, df.tombstones}
+		}
+		return items, bytes
+	}
+	items, bytes := load(1)
+	if len(bytes) < 10 {
+		t.Fatalf("expected at least 10 datafiles, got %d", len(bytes))
+	}
+	if _, ok := items[string(GetKey(3))]; ok {
+		t.Fatal("expected the key deleted by the batch not loaded")
+	}
+	if _, ok := items[string(GetKey(1))]; ok {
+		t.Fatal("expected the expired key not loaded")
+	}
+	if it := items[string(GetKey(0))]; it.size != int64(newDataEntry(GetKey(0), []byte("batch"), markPut|markBatch).Size()) {
+		t.Fatalf("expected the batch entry of key 0, got %+v", it)
+	}
+	for _, n := range []int{2, 8} {
+		got, gotBytes := load(n)
+		if len(got) != len(items) {
+			t.Fatalf("parallelism %d: expected %d keys, got %d", n, len(items), len(got))
+		}
+		for k, it := range items {
+			if got[k] != it {
+				t.Fatalf("parallelism %d: expected %+v of %s, got %+v", n, it, k, got[k])
+			}
+		}
+		// the active datafile of the previous Open is empty
+		for id, b := range bytes {
+			if gotBytes[id] != b {
+				t.Fatalf("parallelism %d: expected live and tombstone bytes %v of datafile %d, got %v", n, b, id, gotBytes[id])
+			}
+		}
+	}
+}
+
+func TestLoadProgress(t *testing.T) {
+	dir := t.TempDir()
+	db := openPut(t, dir, 50, WithMaxFileSize(1000))
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var calls [][2]int
+	db, err := Open(dir, WithMaxFileSize(1000), WithLoadParallelism(4), WithLoadProgress(func(loaded, total int) {
+		calls = append(calls, [2]int{loaded, total})
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	// the active datafile is created after loading
+	total := len(db.datafiles) - 1
+	if len(calls) != total {
+		t.Fatalf("expected %d progress calls, got %d", total, len(calls))
+	}
+	for i, c := range calls {
+		if c != [2]int{i + 1, total} {
+			t.Fatalf("expected progress %d/%d, got %d/%d", i+1, total, c[0], c[1])
+		}
+	}
+}
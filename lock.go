@@ -0,0 +1,24 @@
+package bitcask
+
+import (
+	"os"
+	"path"
+)
+
+// lockFileName is the file flocked by the db in its dir, so two
+// processes can't open the same db for writing
+const lockFileName = "bitcask.lock"
+
+// lockDir locks the db dir exclusively. It returns ErrDatabaseLocked if
+// another db holds the lock. Closing the returned file releases the lock.
+func lockDir(dir string, perm os.FileMode) (*os.File, error) {
+	f, err := os.OpenFile(path.Join(dir, lockFileName), os.O_CREATE|os.O_RDONLY, perm)
+	if err != nil {
+		return nil, err
+	}
+	if err := flock(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
@@ -0,0 +1,11 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package bitcask
+
+import "os"
+
+// flock is not supported, the db dir is not locked
+func flock(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,127 @@
+package bitcask
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+)
+
+func TestDatabaseLocked(t *testing.T) {
+	dir := t.TempDir()
+	db := openPut(t, dir, 1)
+	if _, err := Open(dir); !errors.Is(err, ErrDatabaseLocked) {
+		t.Fatalf("expected ErrDatabaseLocked, got %v", err)
+	}
+	// the read-only db doesn't take the lock
+	r, err := Open(dir, WithReadOnly(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// released by Close
+	db, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	db := openPut(t, dir, 10)
+	name := db.active.f.Name()
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// half of an entry left by a crash
+	_, buf := newDataEntry(GetKey(10), GetValue(10), markPut).Encode()
+	fd, err := os.OpenFile(name, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fd.Write(buf[:len(buf)/2]); err != nil {
+		t.Fatal(err)
+	}
+	fd.Close()
+	fi, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := filepath.Glob(path.Join(dir, "bitcask.*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r1, err := Open(dir, WithReadOnly(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r1.Close()
+	r2, err := Open(dir, WithReadOnly(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r2.Close()
+
+	want := make(map[string]string)
+	for i := 0; i < 10; i++ {
+		want[string(GetKey(i))] = string(GetValue(i))
+	}
+	checkValues(t, r1, want)
+	n := 0
+	if err := r2.ForEachKey(func(key []byte) error {
+		n++
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if n != 10 {
+		t.Fatalf("expected 10 keys, got %d", n)
+	}
+	if err := r1.Put(GetKey(0), GetValue(0)); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("expected ErrReadOnly, got %v", err)
+	}
+	if err := r1.Del(GetKey(0)); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("expected ErrReadOnly, got %v", err)
+	}
+	if _, err := r1.Merge(context.Background()); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("expected ErrReadOnly, got %v", err)
+	}
+
+	// no file is created, and the torn tail is kept
+	got, err := filepath.Glob(path.Join(dir, "bitcask.*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(files) {
+		t.Fatalf("expected files %v, got %v", files, got)
+	}
+	nfi, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nfi.Size() != fi.Size() {
+		t.Fatalf("expected datafile size %d, got %d", fi.Size(), nfi.Size())
+	}
+}
+
+func TestReadOnlyMissingDir(t *testing.T) {
+	dir := path.Join(t.TempDir(), "db")
+	if _, err := Open(dir, WithReadOnly(true)); err == nil {
+		t.Fatal("expected error opening a missing dir read-only")
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Fatalf("expected the dir not created, got %v", err)
+	}
+}
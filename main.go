@@ -1,7 +0,0 @@
-package main
-
-import "time"
-
-func main() {
-	time.Now().Unix()
-}
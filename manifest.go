@@ -0,0 +1,130 @@
+package bitcask
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const manifestFileName = "bitcask.manifest"
+
+// manifest records the merge moving its datafiles into the db dir. Open
+// removes the merged datafiles of an uncommitted manifest, and the
+// obsolete datafiles of a committed one, so a merge interrupted by a
+// crash is rolled back or forward.
+type manifest struct {
+	// set once all the merged datafiles are moved
+	Committed bool    `json:"committed"`
+	Merged    []int64 `json:"merged"`
+	Obsolete  []int64 `json:"obsolete"`
+	// obsolete datafiles of the committed merge before, still pinned by
+	// iterators when the manifest replaced its own. They are removed
+	// whether the manifest is committed or not.
+	Pending []int64 `json:"pending,omitempty"`
+}
+
+// writeManifest writes m to a temp file and renames it over the manifest,
+// so the manifest is either the old one or m after a crash
+func writeManifest(dir string, m *manifest, perm os.FileMode) error {
+	buf, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	tmp := path.Join(dir, manifestFileName+".tmp")
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path.Join(dir, manifestFileName)); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// readManifest returns nil if there is no manifest in dir
+func readManifest(dir string) (*manifest, error) {
+	buf, err := os.ReadFile(path.Join(dir, manifestFileName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	m := &manifest{}
+	if err := json.Unmarshal(buf, m); err != nil {
+		return nil, fmt.Errorf("bitcask: decode manifest: %w", err)
+	}
+	return m, nil
+}
+
+func removeManifest(dir string) error {
+	err := os.Remove(path.Join(dir, manifestFileName))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// syncDir syncs dir, so the renames and removes in it are durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// recoverMerge rolls the merge recorded by the manifest back or forward,
+// before the index is loaded. A read-only db only ignores the datafiles.
+func (db *Bitcask) recoverMerge() error {
+	m, err := readManifest(db.dir)
+	if err != nil || m == nil {
+		return err
+	}
+	ids := m.Merged
+	if m.Committed {
+		ids = m.Obsolete
+	}
+	ids = append(append([]int64(nil), ids...), m.Pending...)
+	for _, id := range ids {
+		if df, ok := db.datafiles[id]; ok {
+			df.Close()
+			delete(db.datafiles, id)
+		}
+		if hf, ok := db.hintfiles[id]; ok {
+			hf.Close()
+			delete(db.hintfiles, id)
+		}
+		if db.opts.readOnly {
+			continue
+		}
+		for _, name := range []string{fmt.Sprintf(dataFilePrefix, id), fmt.Sprintf(hintFilePrefix, id)} {
+			if err := os.Remove(path.Join(db.dir, name)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	log.WithFields(log.Fields{
+		"committed": m.Committed,
+		"removed":   ids,
+	}).Warn("recover interrupted merge")
+	if db.opts.readOnly {
+		return nil
+	}
+	return removeManifest(db.dir)
+}
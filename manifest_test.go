@@ -0,0 +1,265 @@
+package bitcask
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+)
+
+func copyFile(t *testing.T, src, dst string) {
+	t.Helper()
+	buf, err := os.ReadFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dst, buf, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMergeNoManifest(t *testing.T) {
+	dir := t.TempDir()
+	db := openPut(t, dir, 10)
+	defer db.Close()
+	if _, err := db.Merge(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path.Join(dir, manifestFileName)); !os.IsNotExist(err) {
+		t.Fatalf("expected the manifest removed, got %v", err)
+	}
+}
+
+func TestMergeRollForward(t *testing.T) {
+	dir := t.TempDir()
+	// 10 entries per datafile
+	db := openPut(t, dir, 10, WithMaxFileSize(1000))
+	first := db.currID
+	for i := 0; i < 5; i++ {
+		if err := db.Del(GetKey(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	backup := t.TempDir()
+	copyFile(t, path.Join(dir, fmt.Sprintf(dataFilePrefix, first)), path.Join(backup, "data"))
+	if _, err := db.Merge(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// crashed after the merge is committed, removing the datafile of the
+	// tombstones but not the first one
+	copyFile(t, path.Join(backup, "data"), path.Join(dir, fmt.Sprintf(dataFilePrefix, first)))
+	if err := writeManifest(dir, &manifest{Committed: true, Obsolete: []int64{first, first + 1}}, 0644); err != nil {
+		t.Fatal(err)
+	}
+	db, err := Open(dir, WithMaxFileSize(1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	want := make(map[string]string)
+	for i := 5; i < 10; i++ {
+		want[string(GetKey(i))] = string(GetValue(i))
+	}
+	checkValues(t, db, want)
+	if _, err := os.Stat(path.Join(dir, fmt.Sprintf(dataFilePrefix, first))); !os.IsNotExist(err) {
+		t.Fatalf("expected the obsolete datafile removed, got %v", err)
+	}
+	if _, err := os.Stat(path.Join(dir, manifestFileName)); !os.IsNotExist(err) {
+		t.Fatalf("expected the manifest removed, got %v", err)
+	}
+}
+
+func TestMergeRollBack(t *testing.T) {
+	dir := t.TempDir()
+	db := openPut(t, dir, 10)
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// a merged datafile with a stale value moved before the crash
+	other := t.TempDir()
+	odb := openPut(t, other, 0)
+	if err := odb.Put(GetKey(0), []byte("stale")); err != nil {
+		t.Fatal(err)
+	}
+	name := odb.active.f.Name()
+	if err := odb.Close(); err != nil {
+		t.Fatal(err)
+	}
+	merged := path.Join(dir, fmt.Sprintf(dataFilePrefix, 100))
+	copyFile(t, name, merged)
+	if err := writeManifest(dir, &manifest{Merged: []int64{100, 101}, Obsolete: []int64{0}}, 0644); err != nil {
+		t.Fatal(err)
+	}
+	want := make(map[string]string)
+	for i := 0; i < 10; i++ {
+		want[string(GetKey(i))] = string(GetValue(i))
+	}
+
+	// a read-only db ignores the merged datafile without removing it
+	db, err := Open(dir, WithReadOnly(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkValues(t, db, want)
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(merged); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	checkValues(t, db, want)
+	if _, err := os.Stat(merged); !os.IsNotExist(err) {
+		t.Fatalf("expected the merged datafile removed, got %v", err)
+	}
+	if _, err := os.Stat(path.Join(dir, manifestFileName)); !os.IsNotExist(err) {
+		t.Fatalf("expected the manifest removed, got %v", err)
+	}
+}
+
+func TestMergeManifestPinned(t *testing.T) {
+	for _, closeIter := range []bool{true, false} {
+		dir := t.TempDir()
+		db := openPut(t, dir, 10)
+		iter, err := db.Iterator()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Merge(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		// the old datafile pinned by the iterator is recorded
+		m, err := readManifest(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m == nil || !m.Committed || len(m.Obsolete) == 0 {
+			t.Fatalf("expected the committed manifest, got %+v", m)
+		}
+		// removed with the last obsolete datafile, by the iterator or
+		// by the db
+		if closeIter {
+			if err := iter.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if m, err := readManifest(dir); m != nil || err != nil {
+				t.Fatalf("expected the manifest removed by the iterator, got %+v %v", m, err)
+			}
+		} else {
+			defer iter.Close()
+		}
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if m, err := readManifest(dir); m != nil || err != nil {
+			t.Fatalf("close iterator %v: expected the manifest removed, got %+v %v", closeIter, m, err)
+		}
+		db, err = Open(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if db.Keys() != 10 {
+			t.Fatalf("expected 10 keys, got %d", db.Keys())
+		}
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReadManifestCorrupt(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(path.Join(dir, manifestFileName), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(dir); err == nil || errors.Is(err, ErrDatabaseLocked) {
+		t.Fatalf("expected decode error, got %v", err)
+	}
+}
+
+func TestMergeManifestPinnedCrash(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, WithMaxFileSize(50))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, k := range []string{"k1", "k2"} {
+		if err := db.Put([]byte(k), []byte("v"+k[1:])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	first := db.currID
+	// the tombstone is in the next datafile
+	if err := db.Del([]byte("k1")); err != nil {
+		t.Fatal(err)
+	}
+	if db.currID == first {
+		t.Fatal("expected the tombstone in a new datafile")
+	}
+	// pins the datafile of k2, which has the deleted value of k1 too
+	iter, err := db.Iterator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer iter.Close()
+	if _, err := db.Merge(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	crash := t.TempDir()
+	files, err := filepath.Glob(path.Join(dir, "bitcask.*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		copyFile(t, file, path.Join(crash, filepath.Base(file)))
+	}
+	if _, err := db.Merge(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// the second manifest records the pinned datafile of the first merge
+	m, err := readManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m == nil || len(m.Pending) != 1 || m.Pending[0] != first {
+		t.Fatalf("expected datafile %d pending, got %+v", first, m)
+	}
+
+	// crash after the second merge writes its manifest, before it
+	// moves its datafiles
+	m.Committed = false
+	if err := writeManifest(crash, m, 0644); err != nil {
+		t.Fatal(err)
+	}
+	cdb, err := Open(crash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cdb.Close()
+	if _, err := cdb.Get([]byte("k1")); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+	val, err := cdb.Get([]byte("k2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(val) != "v2" {
+		t.Fatalf("expected v2, got %s", val)
+	}
+	if _, err := os.Stat(path.Join(crash, fmt.Sprintf(dataFilePrefix, first))); !os.IsNotExist(err) {
+		t.Fatalf("expected the pinned datafile removed, got %v", err)
+	}
+}
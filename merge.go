@@ -0,0 +1,382 @@
+package bitcask
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// MergeStats is the result of a merge
+type MergeStats struct {
+	// KeysRewritten is the number of live keys written to the merged datafiles
+	KeysRewritten int
+	// BytesReclaimed is the size of the removed datafiles minus the size of
+	// the merged datafiles
+	BytesReclaimed int64
+	Duration       time.Duration
+}
+
+// mergeOptions configures a Merge
+type mergeOptions struct {
+	// incremental merge if positive
+	fileRatio float64
+}
+
+// MergeOption configures a Merge
+type MergeOption func(*mergeOptions)
+
+// WithIncremental makes Merge rewrite only the sealed datafiles whose dead
+// bytes reach ratio of their size, each one in place, leaving the other
+// datafiles untouched. The tombstones are kept since they may delete the
+// entries of other datafiles, only a full merge removes them. A ratio of 0
+// means a full merge.
+func WithIncremental(ratio float64) MergeOption {
+	return func(o *mergeOptions) {
+		o.fileRatio = ratio
+	}
+}
+
+func (o *mergeOptions) validate() error {
+	if o.fileRatio < 0 || o.fileRatio > 1 {
+		return fmt.Errorf("%w: incremental merge ratio %v must be in [0, 1], 0 for a full merge", ErrInvalidOption, o.fileRatio)
+	}
+	return nil
+}
+
+// Merge rewrites the live k-v into new datafiles with hint files, and
+// removes the old datafiles. The db is writable during the merge. It
+// returns ErrMergeInProgress if another merge is running. If ctx is done
+// before the merged datafiles are moved into the db dir, the merge is
+// aborted and its output is removed.
+func (db *Bitcask) Merge(ctx context.Context, opts ...MergeOption) (*MergeStats, error) {
+	o := &mergeOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+	if db.opts.readOnly {
+		return nil, ErrReadOnly
+	}
+	db.mu.Lock()
+	if db.closed {
+		db.mu.Unlock()
+		return nil, ErrClosed
+	}
+	if db.isMerging {
+		db.mu.Unlock()
+		return nil, ErrMergeInProgress
+	}
+	db.isMerging = true
+	db.merging.Add(1)
+	db.mu.Unlock()
+	defer func() {
+		db.mu.Lock()
+		db.isMerging = false
+		db.mu.Unlock()
+		db.merging.Done()
+	}()
+	if o.fileRatio > 0 {
+		return db.mergeFiles(ctx, o.fileRatio)
+	}
+	return db.merge(ctx)
+}
+
+func (db *Bitcask) merge(ctx context.Context) (*MergeStats, error) {
+	db.foldMu.Lock()
+	defer db.foldMu.Unlock()
+	start := time.Now()
+
+	// like copy-on-write
+	tmpdir, err := db.mergeTempDir()
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpdir)
+	// tmpdir no datafile, currid=0
+	// mdb is synced when closed, not on every put
+	mopts := *db.opts
+	mopts.syncPolicy = SyncNever
+	mopts.mergeInterval = 0
+	// merge writes the hint files of mdb
+	mopts.sealHint = false
+	mdb, err := open(tmpdir, &mopts)
+	if err != nil {
+		return nil, err
+	}
+	// closed before the merged datafiles are moved, closing it again
+	// only returns ErrClosed
+	defer mdb.Close()
+
+	// copy index
+	db.mu.Lock()
+	snapshot := make(map[string]*item, db.index.Len())
+	db.index.ForEach(func(k string, v *item) bool {
+		snapshot[k] = v
+		return true
+	})
+	lastid := db.currID
+	var oldSize int64
+	for id, df := range db.datafiles {
+		if id <= lastid {
+			oldSize += df.Size()
+		}
+	}
+	// the merged datafiles take the ids after lastid, so the entries
+	// written during merge, in the datafiles after them, are loaded last.
+	// A merged datafile is sealed when the next entry doesn't fit, so two
+	// of them hold more than max file size, plus a possible empty one.
+	reserved := 2*oldSize/db.opts.maxFileSize + 2
+	err = db.rotate(lastid + reserved + 1)
+	db.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &MergeStats{}
+	hf := newHintFile(db.opts.fileMode)
+	defer hf.Close()
+	now := time.Now().UnixNano()
+	// expired keys are not rewritten
+	expired := make(map[string]*item)
+	// mdb rebuild datafile
+	for k, v := range snapshot {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		select {
+		case <-db.closing:
+			return nil, ErrClosed
+		default:
+		}
+		if v.expired(now) {
+			expired[k] = v
+			continue
+		}
+		db.mu.RLock()
+		file := db.datafiles[v.fileID]
+		db.mu.RUnlock()
+		// 随机读
+		entry, err := db.get(file, v)
+		if err != nil {
+			return nil, err
+		}
+		// 顺序append
+		if err := mdb.put(entry.key, entry.value, entry.expiry); err != nil {
+			return nil, err
+		}
+		// write hint file, the same as datafile fileid
+		it, _ := mdb.index.Get(string(entry.key))
+		// 顺序append
+		// ==> bufio write
+		if err := hf.WriteHint(mdb.dir, it.fileID, entry.key, entry.valueSize, it.entryOffset, it.expiry); err != nil {
+			return nil, err
+		}
+		stats.KeysRewritten++
+	}
+	hf.Flush()
+	if err := hf.Close(); err != nil {
+		return nil, err
+	}
+	if int64(len(mdb.datafiles)) > reserved {
+		return nil, fmt.Errorf("bitcask: merge wrote %d datafiles, %d ids reserved", len(mdb.datafiles), reserved)
+	}
+	merged := mdb.index
+	if err := mdb.Close(); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return nil, ErrClosed
+	}
+	startID := lastid + 1
+	obsolete := make([]int64, 0)
+	for id := range db.datafiles {
+		if id <= lastid {
+			obsolete = append(obsolete, id)
+		}
+	}
+	// removed by a previous merge, but still pinned by iterators
+	pending := make([]int64, 0)
+	for df := range db.retired {
+		if !df.replaced {
+			pending = append(pending, df.fileID)
+		}
+	}
+	dfs, err := db.moveMerged(tmpdir, startID, obsolete, pending)
+	if err != nil {
+		return nil, err
+	}
+	for _, df := range dfs {
+		stats.BytesReclaimed -= df.Size()
+		db.datafiles[df.fileID] = df
+		db.mmapFile(df)
+	}
+
+	for k, v := range expired {
+		// not updated during merge
+		if it, ok := db.index.Get(k); ok && it == v {
+			db.deleteItem(k)
+		}
+	}
+	merged.ForEach(func(k string, mit *item) bool {
+		// means k-v deleted or has newer value, its entry is written to a
+		// datafile after the reserved ids and loaded after the merged ones
+		it, ok := db.index.Get(k)
+		if !ok || it != snapshot[k] {
+			return true
+		}
+		// update origin db index
+		mit.fileID = mit.fileID + startID
+		// the value is not changed by merge
+		mit.seq = it.seq
+		db.putItem(k, mit)
+		return true
+	})
+	// remove old datafile and hint file, the tombstones of the old
+	// datafiles are dropped with the entries they delete
+	for id, df := range db.datafiles {
+		if id > lastid {
+			continue
+		}
+		stats.BytesReclaimed += df.Size()
+		if err := db.removeHintFile(id); err != nil {
+			log.WithError(err).WithField("fileID", id).Warn("remove merged hint file")
+		}
+		if err := db.retire(df); err != nil {
+			log.WithError(err).WithField("fileID", id).Warn("remove merged datafile")
+		}
+	}
+	// the manifest is kept until the pinned datafiles are removed
+	db.removeMergedManifest()
+	stats.Duration = time.Since(start)
+	return stats, nil
+}
+
+// removeMergedManifest removes the manifest of the last merge once the
+// obsolete datafiles pinned by iterators are removed. The manifest is
+// written and committed with db.mu held, so it is committed here.
+// db.mu must be held.
+func (db *Bitcask) removeMergedManifest() {
+	if db.opts.readOnly {
+		return
+	}
+	for df := range db.retired {
+		if !df.replaced {
+			return
+		}
+	}
+	if err := removeManifest(db.dir); err != nil {
+		log.WithError(err).Warn("remove manifest of merge")
+	}
+}
+
+// mergeTempDir creates the scratch dir of a merge in the merge dir, a dir
+// per merge so the dbs sharing the merge dir don't clobber each other
+func (db *Bitcask) mergeTempDir() (string, error) {
+	mergeDir := db.opts.mergePath(db.dir)
+	if err := os.MkdirAll(mergeDir, db.opts.dirMode); err != nil {
+		return "", err
+	}
+	return os.MkdirTemp(mergeDir, "merge-")
+}
+
+// moveMerged moves the datafiles and hint files of tmpdir to the db dir,
+// adding startID to their ids, and opens the datafiles. The move is
+// recorded by the manifest with the obsolete datafile ids, and committed
+// when all files are moved. pending is the obsolete datafiles of the
+// previous merge still pinned by iterators, its manifest is replaced so
+// they are recorded too. The moved files are removed and the previous
+// manifest is restored on failure.
+func (db *Bitcask) moveMerged(tmpdir string, startID int64, obsolete, pending []int64) ([]*dataFile, error) {
+	datas, err := filepath.Glob(path.Join(tmpdir, dataFilePattern))
+	if err != nil {
+		return nil, err
+	}
+	hints, err := filepath.Glob(path.Join(tmpdir, hintFilePattern))
+	if err != nil {
+		return nil, err
+	}
+	prev, err := readManifest(db.dir)
+	if err != nil {
+		return nil, err
+	}
+	m := &manifest{Obsolete: obsolete, Pending: pending}
+	for _, file := range append(hints, datas...) {
+		// mdb only syncs its active datafile
+		if err := syncFile(file); err != nil {
+			return nil, err
+		}
+	}
+	for _, file := range datas {
+		m.Merged = append(m.Merged, getFileID(file)+startID)
+	}
+	if err := writeManifest(db.dir, m, db.opts.fileMode); err != nil {
+		return nil, err
+	}
+
+	moved := make([]string, 0, len(datas)+len(hints))
+	dfs := make([]*dataFile, 0, len(datas))
+	fail := func(err error) ([]*dataFile, error) {
+		for _, df := range dfs {
+			df.Close()
+		}
+		for _, file := range moved {
+			os.Remove(file)
+		}
+		rerr := removeManifest(db.dir)
+		if prev != nil {
+			// the obsolete datafiles of the previous merge are still pinned
+			rerr = writeManifest(db.dir, prev, db.opts.fileMode)
+		}
+		if rerr != nil {
+			// Open removes the merged datafiles
+			log.WithError(rerr).Warn("restore manifest of failed merge")
+		}
+		return nil, err
+	}
+	// move hint file, don't need to open it
+	for _, file := range append(hints, datas...) {
+		newfile := getNewFileName(tmpdir, db.dir, file, startID)
+		if err := os.Rename(file, newfile); err != nil {
+			return fail(err)
+		}
+		moved = append(moved, newfile)
+	}
+	for _, file := range datas {
+		df, err := openDataFile(db.dir, getFileID(file)+startID, false, db.opts.fileMode)
+		if err != nil {
+			return fail(err)
+		}
+		dfs = append(dfs, df)
+	}
+	if err := syncDir(db.dir); err != nil {
+		return fail(err)
+	}
+	m.Committed = true
+	if err := writeManifest(db.dir, m, db.opts.fileMode); err != nil {
+		return fail(err)
+	}
+	return dfs, nil
+}
+
+// syncFile syncs the closed file
+func syncFile(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
@@ -0,0 +1,202 @@
+package bitcask
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func checkValues(t *testing.T, db *Bitcask, want map[string]string) {
+	t.Helper()
+	if db.Keys() != len(want) {
+		t.Fatalf("expected %d keys, got %d", len(want), db.Keys())
+	}
+	for k, v := range want {
+		val, err := db.Get([]byte(k))
+		if err != nil {
+			t.Fatalf("get %s: %v", k, err)
+		}
+		if string(val) != v {
+			t.Fatalf("expected %s of %s, got %s", v, k, val)
+		}
+	}
+}
+
+func TestMergeStats(t *testing.T) {
+	db := openPut(t, t.TempDir(), 100)
+	defer db.Close()
+	for i := 0; i < 50; i++ {
+		if err := db.Put(GetKey(i), GetValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stats, err := db.Merge(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.KeysRewritten != 100 {
+		t.Fatalf("expected 100 keys rewritten, got %d", stats.KeysRewritten)
+	}
+	// the overwritten entries
+	var size int64
+	for i := 0; i < 50; i++ {
+		size += int64(newDataEntry(GetKey(i), GetValue(i), markPut).Size())
+	}
+	if stats.BytesReclaimed != size {
+		t.Fatalf("expected %d bytes reclaimed, got %d", size, stats.BytesReclaimed)
+	}
+	if stats.Duration <= 0 {
+		t.Fatalf("unexpected duration %v", stats.Duration)
+	}
+}
+
+func TestMergeCanceled(t *testing.T) {
+	dir := t.TempDir()
+	db := openPut(t, dir, 100, WithMaxFileSize(1024))
+	defer db.Close()
+	files, err := filepath.Glob(filepath.Join(dir, dataFilePattern))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := db.Merge(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	// the old datafiles are kept, and the scratch dir is removed
+	for _, file := range files {
+		if _, err := os.Stat(file); err != nil {
+			t.Fatal(err)
+		}
+	}
+	scratch, err := os.ReadDir(filepath.Join(dir, defaultMergeDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scratch) != 0 {
+		t.Fatalf("expected empty merge dir, got %d entries", len(scratch))
+	}
+
+	// the failed merge doesn't block the next one
+	if _, err := db.Merge(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	want := make(map[string]string)
+	for i := 0; i < 100; i++ {
+		want[string(GetKey(i))] = string(GetValue(i))
+	}
+	checkValues(t, db, want)
+}
+
+func TestMergeInProgress(t *testing.T) {
+	db := openPut(t, t.TempDir(), 10)
+	defer db.Close()
+
+	errCh := make(chan error, 1)
+	// the merge waits for the fold
+	err := db.ForEachKey(func(key []byte) error {
+		go func() {
+			_, err := db.Merge(context.Background())
+			errCh <- err
+		}()
+		for {
+			db.mu.RLock()
+			merging := db.isMerging
+			db.mu.RUnlock()
+			if merging {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+		if _, err := db.Merge(context.Background()); !errors.Is(err, ErrMergeInProgress) {
+			t.Fatalf("expected ErrMergeInProgress, got %v", err)
+		}
+		return errors.New("stop")
+	})
+	if err == nil {
+		t.Fatal("expected stop")
+	}
+	if err := <-errCh; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMergeConcurrentWrites(t *testing.T) {
+	dir := t.TempDir()
+	db := openPut(t, dir, 1000, WithMaxFileSize(4096))
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			if i%3 == 0 {
+				db.Del(GetKey(i))
+				continue
+			}
+			db.Put(GetKey(i), []byte("new"))
+		}
+	}()
+	if _, err := db.Merge(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	want := make(map[string]string)
+	for i := 0; i < 1000; i++ {
+		if i%3 == 0 {
+			continue
+		}
+		want[string(GetKey(i))] = "new"
+	}
+	checkValues(t, db, want)
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// the entries written during merge are loaded after the merged ones
+	db, err := Open(dir, WithMaxFileSize(4096))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	checkValues(t, db, want)
+}
+
+func TestMergeSharedMergeDir(t *testing.T) {
+	mergeDir := t.TempDir()
+	dbs := make([]*Bitcask, 2)
+	for i := range dbs {
+		dbs[i] = openPut(t, t.TempDir(), 100, WithMergeDir(mergeDir), WithMaxFileSize(1024))
+		defer dbs[i].Close()
+		for j := 0; j < 100; j++ {
+			if err := dbs[i].Put(GetKey(j), []byte{byte(i)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	var wg sync.WaitGroup
+	errs := make([]error, len(dbs))
+	for i := range dbs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = dbs[i].Merge(context.Background())
+		}(i)
+	}
+	wg.Wait()
+	for i, db := range dbs {
+		if errs[i] != nil {
+			t.Fatal(errs[i])
+		}
+		want := make(map[string]string)
+		for j := 0; j < 100; j++ {
+			want[string(GetKey(j))] = string([]byte{byte(i)})
+		}
+		checkValues(t, db, want)
+	}
+}
@@ -0,0 +1,101 @@
+package bitcask
+
+import (
+	"context"
+	"runtime"
+	"testing"
+)
+
+func TestMmap(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("mmap is not supported")
+	}
+	dir := t.TempDir()
+	// 10 entries per datafile
+	opts := []Option{WithMaxFileSize(1000), WithMmap(true)}
+	db := openPut(t, dir, 30, opts...)
+	check := func(db *Bitcask) {
+		t.Helper()
+		for id, df := range db.datafiles {
+			if mapped := df.data != nil; mapped != (df != db.active) {
+				t.Fatalf("datafile %d: expected mapped %v, got %v", id, df != db.active, mapped)
+			}
+		}
+		want := make(map[string]string)
+		for i := 0; i < 30; i++ {
+			want[string(GetKey(i))] = string(GetValue(i))
+		}
+		checkValues(t, db, want)
+	}
+	check(db)
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := Open(dir, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	check(db)
+
+	// the iterator reads the mapped datafiles retired by merge
+	iter, err := db.Iterator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer iter.Close()
+	if _, err := db.Merge(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	check(db)
+	n := 0
+	for ; iter.Valid(); iter.Next() {
+		val, err := iter.Value()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(val) != string(GetValue(n)) {
+			t.Fatalf("expected %s, got %s", GetValue(n), val)
+		}
+		n++
+	}
+	if n != 30 {
+		t.Fatalf("expected 30 keys, got %d", n)
+	}
+}
+
+func TestZeroCopy(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("mmap is not supported")
+	}
+	for _, zeroCopy := range []bool{false, true} {
+		db := openPut(t, t.TempDir(), 11, WithMaxFileSize(900), WithMmap(true), WithZeroCopy(zeroCopy))
+		v1, err := db.Get(GetKey(0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		v2, err := db.Get(GetKey(0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the values read with zero copy share the mapped memory
+		if shared := &v1[0] == &v2[0]; shared != zeroCopy {
+			t.Fatalf("zero copy %v: expected shared %v, got %v", zeroCopy, zeroCopy, shared)
+		}
+		if string(v1) != string(GetValue(0)) {
+			t.Fatalf("expected %s, got %s", GetValue(0), v1)
+		}
+		// the values used after the lock is released, by Expire, are copied
+		_, v3, err := db.getItem(GetKey(0), true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if &v3[0] == &v1[0] || string(v3) != string(v1) {
+			t.Fatalf("zero copy %v: expected an owned copy of %s", zeroCopy, v1)
+		}
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
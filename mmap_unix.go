@@ -0,0 +1,22 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package bitcask
+
+import (
+	"os"
+	"syscall"
+)
+
+// mmap maps the first size bytes of f read-only, nil if size doesn't fit
+// in an int
+func mmap(f *os.File, size int64) ([]byte, error) {
+	if int64(int(size)) != size {
+		return nil, nil
+	}
+	return syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+func munmap(data []byte) error {
+	return syscall.Munmap(data)
+}
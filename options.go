@@ -0,0 +1,355 @@
+package bitcask
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path"
+	"runtime"
+	"time"
+)
+
+const (
+	defaultMaxFileSize  = 1 << 30
+	defaultMaxKeySize   = math.MaxUint32
+	defaultMaxValueSize = math.MaxInt64 - metaLen - math.MaxUint32
+	defaultDirMode      = os.ModePerm
+	defaultFileMode     = os.ModePerm
+	defaultMergeDir     = "tmp_db"
+	defaultSyncInterval = time.Second
+)
+
+// SyncPolicy decides when the writes of the active datafile are synced to disk
+type SyncPolicy int
+
+const (
+	// SyncNever leaves the sync to the OS, the datafile is synced when sealed or closed
+	SyncNever SyncPolicy = iota
+	// SyncAlways syncs after every write, Put returns after the entry is durable
+	SyncAlways
+	// SyncInterval syncs in background every sync interval
+	SyncInterval
+)
+
+// ReadPolicy decides how Get reads the value of a key and verifies it
+type ReadPolicy int
+
+const (
+	// ReadFull reads the whole entry with a single read and checks its crc
+	ReadFull ReadPolicy = iota
+	// ReadValue reads the meta and the value of the entry without the key,
+	// and checks the crc with the key being read
+	ReadValue
+	// ReadValueUnchecked reads only the value without checking the crc
+	ReadValueUnchecked
+)
+
+type options struct {
+	maxFileSize  int64
+	maxKeySize   uint32
+	maxValueSize uint64
+	dirMode      os.FileMode
+	fileMode     os.FileMode
+	// merge scratch dir, relative to the db dir if not absolute
+	mergeDir     string
+	skipCorrupt  bool
+	syncPolicy   SyncPolicy
+	readPolicy   ReadPolicy
+	syncInterval time.Duration
+	keydir       KeydirType
+	// auto merge, disabled if mergeInterval is 0
+	mergeInterval  time.Duration
+	mergeRatio     float64
+	mergeDeadBytes int64
+	// time of day, no window if equal
+	mergeWindowStart time.Duration
+	mergeWindowEnd   time.Duration
+	// write the hint files of the sealed datafiles in background
+	sealHint bool
+	// datafiles loaded in parallel by Open
+	loadParallelism int
+	loadProgress    func(loaded, total int)
+	// the db dir is not locked or written
+	readOnly bool
+	// Refresh the read-only db in background, disabled if 0
+	refreshInterval time.Duration
+	// read the sealed datafiles from mmap, and return the values without
+	// copying if zeroCopy
+	mmap     bool
+	zeroCopy bool
+}
+
+// Option configures the db in Open
+type Option func(*options)
+
+func defaultOptions() *options {
+	return &options{
+		maxFileSize:     defaultMaxFileSize,
+		maxKeySize:      defaultMaxKeySize,
+		maxValueSize:    defaultMaxValueSize,
+		dirMode:         defaultDirMode,
+		fileMode:        defaultFileMode,
+		mergeDir:        defaultMergeDir,
+		syncPolicy:      SyncNever,
+		syncInterval:    defaultSyncInterval,
+		keydir:          HashKeydir,
+		sealHint:        true,
+		loadParallelism: runtime.NumCPU(),
+	}
+}
+
+// WithMaxFileSize sets the size at which the active datafile is sealed
+// and a new one is opened
+func WithMaxFileSize(size int64) Option {
+	return func(o *options) {
+		o.maxFileSize = size
+	}
+}
+
+// WithMaxKeySize sets the max key size accepted by Put
+func WithMaxKeySize(size uint32) Option {
+	return func(o *options) {
+		o.maxKeySize = size
+	}
+}
+
+// WithMaxValueSize sets the max value size accepted by Put
+func WithMaxValueSize(size uint64) Option {
+	return func(o *options) {
+		o.maxValueSize = size
+	}
+}
+
+// WithDirMode sets the permission of the directories created by the db
+func WithDirMode(mode os.FileMode) Option {
+	return func(o *options) {
+		o.dirMode = mode
+	}
+}
+
+// WithFileMode sets the permission of the datafiles and hint files
+// created by the db
+func WithFileMode(mode os.FileMode) Option {
+	return func(o *options) {
+		o.fileMode = mode
+	}
+}
+
+// WithMergeDir sets the scratch dir used by Merge. A relative dir is
+// joined to the db dir. It must be on the same filesystem as the db dir,
+// since merged files are renamed into place.
+func WithMergeDir(dir string) Option {
+	return func(o *options) {
+		o.mergeDir = dir
+	}
+}
+
+// WithSkipCorrupt makes Open skip the corrupt entries found in sealed
+// datafiles instead of failing. The entries after a truncated entry in a
+// sealed datafile are skipped too, since the next entry can't be located.
+func WithSkipCorrupt(skip bool) Option {
+	return func(o *options) {
+		o.skipCorrupt = skip
+	}
+}
+
+// WithReadPolicy sets how the values are read by Get, transactions and
+// iterators, ReadFull by default. ReadValue saves reading the key, which
+// matters for large keys. ReadValueUnchecked trades the crc check for speed.
+func WithReadPolicy(policy ReadPolicy) Option {
+	return func(o *options) {
+		o.readPolicy = policy
+	}
+}
+
+// WithSyncPolicy sets when the writes are synced to disk, SyncNever by default
+func WithSyncPolicy(policy SyncPolicy) Option {
+	return func(o *options) {
+		o.syncPolicy = policy
+	}
+}
+
+// WithSyncInterval sets the interval of SyncInterval policy
+func WithSyncInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.syncInterval = interval
+	}
+}
+
+// WithKeydir sets the in-memory index, HashKeydir by default. BTreeKeydir
+// makes Scan and Range walk only the matching keys, at the cost of slower
+// Get and Put.
+func WithKeydir(t KeydirType) Option {
+	return func(o *options) {
+		o.keydir = t
+	}
+}
+
+// WithAutoMerge merges in background when the dead bytes of the
+// datafiles reach ratio of their size, or deadBytes. The dead bytes are
+// checked every interval. A zero ratio or deadBytes disables its trigger.
+func WithAutoMerge(interval time.Duration, ratio float64, deadBytes int64) Option {
+	return func(o *options) {
+		o.mergeInterval = interval
+		o.mergeRatio = ratio
+		o.mergeDeadBytes = deadBytes
+	}
+}
+
+// WithMergeWindow restricts auto merge to the time of day in [start, end),
+// in local time. The window wraps midnight if end is before start.
+func WithMergeWindow(start, end time.Duration) Option {
+	return func(o *options) {
+		o.mergeWindowStart = start
+		o.mergeWindowEnd = end
+	}
+}
+
+// WithSealHint sets whether the hint file of every sealed datafile is
+// written in background, so Open reads it instead of the datafile.
+// Enabled by default.
+func WithSealHint(enabled bool) Option {
+	return func(o *options) {
+		o.sealHint = enabled
+	}
+}
+
+// WithLoadParallelism sets the number of datafiles loaded in parallel
+// when Open rebuilds the index, the number of CPUs by default
+func WithLoadParallelism(n int) Option {
+	return func(o *options) {
+		o.loadParallelism = n
+	}
+}
+
+// WithLoadProgress sets the callback called by Open after each datafile
+// is applied to the index, with the number of datafiles applied and the
+// total number
+func WithLoadProgress(fn func(loaded, total int)) Option {
+	return func(o *options) {
+		o.loadProgress = fn
+	}
+}
+
+// WithReadOnly opens the db read-only. The read-only db doesn't lock the
+// db dir, so it can be opened with other read-only dbs and the writer.
+// Writes and Merge return ErrReadOnly, and the datafiles are not written:
+// the dir is not created, no datafile or hint file is created and a torn
+// tail is not truncated.
+func WithReadOnly(readOnly bool) Option {
+	return func(o *options) {
+		o.readOnly = readOnly
+	}
+}
+
+// WithMmap maps the sealed datafiles into memory, so they are read
+// without syscalls. The active datafile is read with pread. Disabled by
+// default, and only supported on unix systems.
+func WithMmap(enabled bool) Option {
+	return func(o *options) {
+		o.mmap = enabled
+	}
+}
+
+// WithZeroCopy returns the values read from the mapped datafiles without
+// copying, it needs WithMmap. The values must not be modified, and are
+// invalid once their datafile is closed by Close, removed by Merge or
+// reloaded by Refresh of a read-only db, so they must be copied to be
+// kept. Disabled by default.
+func WithZeroCopy(enabled bool) Option {
+	return func(o *options) {
+		o.zeroCopy = enabled
+	}
+}
+
+// WithRefreshInterval refreshes the read-only db every interval in
+// background, see Refresh. Disabled by default.
+func WithRefreshInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.refreshInterval = interval
+	}
+}
+
+func (o *options) validate() error {
+	if o.maxFileSize <= 0 {
+		return fmt.Errorf("%w: max file size %d must be positive", ErrInvalidOption, o.maxFileSize)
+	}
+	if o.maxKeySize == 0 {
+		return fmt.Errorf("%w: max key size must be positive", ErrInvalidOption)
+	}
+	if o.maxValueSize == 0 || o.maxValueSize > defaultMaxValueSize {
+		return fmt.Errorf("%w: max value size %d must be in (0, %d]", ErrInvalidOption, o.maxValueSize, uint64(defaultMaxValueSize))
+	}
+	if o.dirMode&^os.ModePerm != 0 || o.dirMode&0700 != 0700 {
+		return fmt.Errorf("%w: dir mode %v must be a permission with owner rwx", ErrInvalidOption, o.dirMode)
+	}
+	if o.fileMode&^os.ModePerm != 0 || o.fileMode&0600 != 0600 {
+		return fmt.Errorf("%w: file mode %v must be a permission with owner rw", ErrInvalidOption, o.fileMode)
+	}
+	if o.syncPolicy < SyncNever || o.syncPolicy > SyncInterval {
+		return fmt.Errorf("%w: unknown sync policy %d", ErrInvalidOption, o.syncPolicy)
+	}
+	if o.readPolicy < ReadFull || o.readPolicy > ReadValueUnchecked {
+		return fmt.Errorf("%w: unknown read policy %d", ErrInvalidOption, o.readPolicy)
+	}
+	if o.syncPolicy == SyncInterval && o.syncInterval <= 0 {
+		return fmt.Errorf("%w: sync interval %v must be positive", ErrInvalidOption, o.syncInterval)
+	}
+	if o.keydir < HashKeydir || o.keydir > BTreeKeydir {
+		return fmt.Errorf("%w: unknown keydir %d", ErrInvalidOption, o.keydir)
+	}
+	if o.mergeInterval < 0 {
+		return fmt.Errorf("%w: auto merge interval %v must not be negative", ErrInvalidOption, o.mergeInterval)
+	}
+	if o.mergeRatio < 0 || o.mergeRatio > 1 {
+		return fmt.Errorf("%w: auto merge ratio %v must be in [0, 1]", ErrInvalidOption, o.mergeRatio)
+	}
+	if o.mergeDeadBytes < 0 {
+		return fmt.Errorf("%w: auto merge dead bytes %d must not be negative", ErrInvalidOption, o.mergeDeadBytes)
+	}
+	if o.mergeInterval > 0 && o.mergeRatio == 0 && o.mergeDeadBytes == 0 {
+		return fmt.Errorf("%w: auto merge needs a ratio or dead bytes", ErrInvalidOption)
+	}
+	for _, d := range []time.Duration{o.mergeWindowStart, o.mergeWindowEnd} {
+		if d < 0 || d >= 24*time.Hour {
+			return fmt.Errorf("%w: merge window %v must be in [0, 24h)", ErrInvalidOption, d)
+		}
+	}
+	if o.loadParallelism <= 0 {
+		return fmt.Errorf("%w: load parallelism %d must be positive", ErrInvalidOption, o.loadParallelism)
+	}
+	if o.mergeDir == "" {
+		return fmt.Errorf("%w: merge dir must not be empty", ErrInvalidOption)
+	}
+	if o.refreshInterval < 0 {
+		return fmt.Errorf("%w: refresh interval %v must not be negative", ErrInvalidOption, o.refreshInterval)
+	}
+	if o.refreshInterval > 0 && !o.readOnly {
+		return fmt.Errorf("%w: refresh interval needs a read-only db", ErrInvalidOption)
+	}
+	if o.zeroCopy && !o.mmap {
+		return fmt.Errorf("%w: zero copy needs mmap", ErrInvalidOption)
+	}
+	return nil
+}
+
+// inMergeWindow reports whether t is in the merge window
+func (o *options) inMergeWindow(t time.Time) bool {
+	start, end := o.mergeWindowStart, o.mergeWindowEnd
+	if start == end {
+		return true
+	}
+	y, m, d := t.Date()
+	since := t.Sub(time.Date(y, m, d, 0, 0, 0, 0, t.Location()))
+	if start < end {
+		return since >= start && since < end
+	}
+	return since >= start || since < end
+}
+
+func (o *options) mergePath(dir string) string {
+	if path.IsAbs(o.mergeDir) {
+		return o.mergeDir
+	}
+	return path.Join(dir, o.mergeDir)
+}
@@ -0,0 +1,142 @@
+package bitcask
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestOpenInvalidOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		opt  Option
+	}{
+		{"zero max file size", WithMaxFileSize(0)},
+		{"zero max key size", WithMaxKeySize(0)},
+		{"zero max value size", WithMaxValueSize(0)},
+		{"dir mode without owner rwx", WithDirMode(0600)},
+		{"file mode without owner rw", WithFileMode(0400)},
+		{"empty merge dir", WithMergeDir("")},
+		{"merge dir is db dir", WithMergeDir(".")},
+		{"unknown sync policy", WithSyncPolicy(SyncPolicy(-1))},
+		{"unknown keydir", WithKeydir(KeydirType(-1))},
+		{"negative auto merge interval", WithAutoMerge(-time.Second, 0.5, 0)},
+		{"auto merge ratio above 1", WithAutoMerge(time.Second, 1.5, 0)},
+		{"negative auto merge dead bytes", WithAutoMerge(time.Second, 0, -1)},
+		{"auto merge without trigger", WithAutoMerge(time.Second, 0, 0)},
+		{"merge window after midnight", WithMergeWindow(time.Hour, 25*time.Hour)},
+		{"zero load parallelism", WithLoadParallelism(0)},
+		{"refresh interval without read-only", WithRefreshInterval(time.Second)},
+		{"zero copy without mmap", WithZeroCopy(true)},
+		{"unknown read policy", WithReadPolicy(ReadValueUnchecked + 1)},
+		{"negative refresh interval", func(o *options) {
+			WithReadOnly(true)(o)
+			WithRefreshInterval(-time.Second)(o)
+		}},
+		{"zero sync interval", func(o *options) {
+			WithSyncPolicy(SyncInterval)(o)
+			WithSyncInterval(0)(o)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Open(t.TempDir(), tt.opt)
+			if !errors.Is(err, ErrInvalidOption) {
+				t.Fatalf("expected ErrInvalidOption, got %v", err)
+			}
+		})
+	}
+}
+
+func TestOpenMaxKeyValueSize(t *testing.T) {
+	db, err := Open(t.TempDir(), WithMaxKeySize(4), WithMaxValueSize(8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.Put([]byte("key"), []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put([]byte("key12"), []byte("value")); !errors.Is(err, ErrKeyTooLarge) {
+		t.Fatalf("expected ErrKeyTooLarge, got %v", err)
+	}
+	if err := db.Put([]byte("key"), []byte("value1234")); !errors.Is(err, ErrValueTooLarge) {
+		t.Fatalf("expected ErrValueTooLarge, got %v", err)
+	}
+}
+
+func TestOpenMaxFileSize(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, WithMaxFileSize(64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for i := 0; i < 10; i++ {
+		if err := db.Put(GetKey(i), GetValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, err := filepath.Glob(filepath.Join(dir, dataFilePattern))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) < 10 {
+		t.Fatalf("expected a datafile per entry, got %d datafiles", len(files))
+	}
+}
+
+func TestOpenFileMode(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "db")
+	db, err := Open(dir, WithDirMode(0700), WithFileMode(0600))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	fi, err := os.Stat(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0700 {
+		t.Fatalf("expected dir mode 0700, got %v", fi.Mode().Perm())
+	}
+	fi, err = os.Stat(db.active.f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Fatalf("expected file mode 0600, got %v", fi.Mode().Perm())
+	}
+}
+
+func TestMergeDir(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, WithMergeDir("scratch"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.Put([]byte("key"), []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Merge(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "scratch")); err != nil {
+		t.Fatal(err)
+	}
+	val, err := db.Get([]byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(val) != "value" {
+		t.Fatalf("expected value, got %s", val)
+	}
+}
@@ -0,0 +1,236 @@
+package bitcask
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// OpenReadOnly opens the db in dir read-only, see WithReadOnly. It can be
+// opened while the writer has the db open, and Refresh loads the entries
+// the writer appends after Open.
+func OpenReadOnly(dir string, opts ...Option) (*Bitcask, error) {
+	return Open(dir, append(opts, WithReadOnly(true))...)
+}
+
+// setLastActive reads the last datafile as the active one of the read-only
+// db, it is not written
+func (db *Bitcask) setLastActive() {
+	db.currID, db.active = -1, nil
+	if id := db.nextID() - 1; id >= 0 {
+		db.currID = id
+		db.active = db.datafiles[id]
+	}
+}
+
+// Refresh loads the entries written to the db dir since the read-only db
+// was opened or last refreshed. The entries appended to the last datafile
+// and the new datafiles are loaded, and the index is rebuilt if the writer
+// merged the datafiles. It does nothing for a writable db.
+func (db *Bitcask) Refresh() error {
+	db.refreshMu.Lock()
+	defer db.refreshMu.Unlock()
+
+	db.mu.RLock()
+	if db.closed {
+		db.mu.RUnlock()
+		return ErrClosed
+	}
+	if !db.opts.readOnly {
+		db.mu.RUnlock()
+		return nil
+	}
+	known := make(map[int64]*dataFile, len(db.datafiles))
+	for id, df := range db.datafiles {
+		known[id] = df
+	}
+	lastID, lastSize := db.currID, int64(0)
+	if db.active != nil {
+		lastSize = db.active.Size()
+	}
+	db.mu.RUnlock()
+
+	ids, ok, err := db.newDataFiles(known, lastID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return db.reload()
+	}
+	return db.catchUp(ids, lastID, lastSize)
+}
+
+// newDataFiles returns the ids of the datafiles created after lastID. ok is
+// false if the known datafiles are merged, so the index must be rebuilt.
+func (db *Bitcask) newDataFiles(known map[int64]*dataFile, lastID int64) ([]int64, bool, error) {
+	m, err := readManifest(db.dir)
+	if err != nil {
+		return nil, false, err
+	}
+	// a merge is moving its datafiles
+	if m != nil {
+		return nil, false, nil
+	}
+	files, err := filepath.Glob(path.Join(db.dir, dataFilePattern))
+	if err != nil {
+		return nil, false, err
+	}
+	ids := make([]int64, 0)
+	found := 0
+	for _, file := range files {
+		id := getFileID(file)
+		df, ok := known[id]
+		if !ok {
+			// moved by a merge
+			if id <= lastID {
+				return nil, false, nil
+			}
+			ids = append(ids, id)
+			continue
+		}
+		fi, err := os.Stat(file)
+		if os.IsNotExist(err) {
+			return nil, false, nil
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		dfi, err := df.f.Stat()
+		if err != nil {
+			return nil, false, err
+		}
+		// rewritten by an incremental merge
+		if !os.SameFile(fi, dfi) {
+			return nil, false, nil
+		}
+		found++
+	}
+	// removed by a merge
+	if found != len(known) {
+		return nil, false, nil
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+	return ids, true, nil
+}
+
+// catchUp loads the entries appended to the last datafile after lastSize,
+// and the new datafiles ids
+func (db *Bitcask) catchUp(ids []int64, lastID, lastSize int64) error {
+	// the last datafile is reopened to read its new size
+	if lastID >= 0 {
+		ids = append([]int64{lastID}, ids...)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	tmp := newBitcask(db.dir, db.opts)
+	tmp.loadTime = time.Now().UnixNano()
+	for _, id := range ids {
+		df, err := openDataFile(db.dir, id, false, db.opts.fileMode)
+		if err != nil {
+			tmp.closeFiles()
+			return err
+		}
+		tmp.datafiles[id] = df
+	}
+	// nothing is appended
+	if len(ids) == 1 && ids[0] == lastID && tmp.datafiles[lastID].Size() == lastSize {
+		return tmp.closeFiles()
+	}
+	partials := make([]*partialIndex, len(ids))
+	for i, id := range ids {
+		var from int64
+		if id == lastID {
+			from = lastSize
+		}
+		partials[i] = newPartialIndex()
+		if err := tmp.loadIndexFromFile(partials[i], tmp.datafiles[id], from, i == len(ids)-1); err != nil {
+			tmp.closeFiles()
+			return err
+		}
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		tmp.closeFiles()
+		return ErrClosed
+	}
+	if old, ok := db.datafiles[lastID]; ok {
+		df := tmp.datafiles[lastID]
+		df.live, df.tombstones = old.live, old.tombstones
+		// the name is the reopened datafile
+		old.replaced = true
+		if err := db.retire(old); err != nil {
+			log.WithError(err).Warn("close refreshed datafile")
+		}
+	}
+	for _, id := range ids {
+		db.datafiles[id] = tmp.datafiles[id]
+	}
+	for i, id := range ids {
+		db.applyPartial(id, partials[i])
+	}
+	db.setLastActive()
+	db.mmapSealed()
+	return nil
+}
+
+// reload rebuilds the index from the datafiles in the db dir, and replaces
+// the datafiles of the read-only db
+func (db *Bitcask) reload() error {
+	tmp := newBitcask(db.dir, db.opts)
+	if err := tmp.load(); err != nil {
+		tmp.closeFiles()
+		return err
+	}
+	// folds read the datafiles by id
+	db.foldMu.Lock()
+	defer db.foldMu.Unlock()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		tmp.closeFiles()
+		return ErrClosed
+	}
+	for _, df := range db.datafiles {
+		// not removed, the writer owns the name
+		df.replaced = true
+		if err := db.retire(df); err != nil {
+			log.WithError(err).Warn("close refreshed datafile")
+		}
+	}
+	for _, hf := range db.hintfiles {
+		hf.Close()
+	}
+	db.index, db.datafiles, db.hintfiles = tmp.index, tmp.datafiles, tmp.hintfiles
+	db.setLastActive()
+	db.mmapSealed()
+	log.WithField("dir", db.dir).Info("reload read-only db merged by the writer")
+	return nil
+}
+
+// refreshLoop refreshes the read-only db every refresh interval until the
+// db is closed
+func (db *Bitcask) refreshLoop() {
+	defer db.bg.Done()
+	ticker := time.NewTicker(db.opts.refreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-db.closing:
+			return
+		case <-ticker.C:
+			// a datafile removed by a merge while loading, retried next time
+			if err := db.Refresh(); err != nil && err != ErrClosed {
+				log.WithError(err).Warn("refresh read-only db")
+			}
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package bitcask
+
+import (
+	"context"
+	"errors"
+	"path"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestOpenReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	w := openPut(t, dir, 10)
+	defer w.Close()
+	files, err := filepath.Glob(path.Join(dir, "bitcask.*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// opened with the writer
+	r, err := OpenReadOnly(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	want := make(map[string]string)
+	for i := 0; i < 10; i++ {
+		want[string(GetKey(i))] = string(GetValue(i))
+	}
+	checkValues(t, r, want)
+	if err := r.Put(GetKey(0), GetValue(0)); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("expected ErrReadOnly, got %v", err)
+	}
+	got, err := filepath.Glob(path.Join(dir, "bitcask.*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(files) {
+		t.Fatalf("expected files %v, got %v", files, got)
+	}
+
+	// the entries written after Open are loaded by Refresh
+	if err := w.Put(GetKey(10), GetValue(10)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Get(GetKey(10)); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+	if err := r.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	want[string(GetKey(10))] = string(GetValue(10))
+	checkValues(t, r, want)
+}
+
+func TestRefresh(t *testing.T) {
+	dir := t.TempDir()
+	// 10 entries per datafile
+	opts := []Option{WithMaxFileSize(1000), WithSealHint(false)}
+	w := openPut(t, dir, 15, opts...)
+	defer w.Close()
+	r, err := OpenReadOnly(dir, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	sealed := r.datafiles[0]
+
+	for i := 15; i < 40; i++ {
+		if err := w.Put(GetKey(i), GetValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Del(GetKey(0)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Del(GetKey(12)); err != nil {
+		t.Fatal(err)
+	}
+	b := NewBatch()
+	b.Put(GetKey(1), []byte("batch"))
+	b.Delete(GetKey(20))
+	if err := w.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	want := make(map[string]string)
+	for i := 0; i < 40; i++ {
+		want[string(GetKey(i))] = string(GetValue(i))
+	}
+	delete(want, string(GetKey(0)))
+	delete(want, string(GetKey(12)))
+	delete(want, string(GetKey(20)))
+	want[string(GetKey(1))] = "batch"
+	checkValues(t, r, want)
+	// loaded incrementally
+	if r.datafiles[0] != sealed {
+		t.Fatal("expected the sealed datafile kept")
+	}
+	if r.currID != w.currID {
+		t.Fatalf("expected the last datafile %d, got %d", w.currID, r.currID)
+	}
+}
+
+func TestRefreshMerge(t *testing.T) {
+	dir := t.TempDir()
+	opts := []Option{WithMaxFileSize(1000)}
+	w := openPut(t, dir, 30, opts...)
+	defer w.Close()
+	r, err := OpenReadOnly(dir, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	iter, err := r.Iterator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer iter.Close()
+
+	for i := 0; i < 10; i++ {
+		if err := w.Del(GetKey(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := w.Merge(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Put(GetKey(30), GetValue(30)); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	want := make(map[string]string)
+	for i := 10; i < 31; i++ {
+		want[string(GetKey(i))] = string(GetValue(i))
+	}
+	checkValues(t, r, want)
+
+	// the iterator still reads the datafiles removed by the merge
+	n := 0
+	for ; iter.Valid(); iter.Next() {
+		val, err := iter.Value()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(val) != string(GetValue(n)) {
+			t.Fatalf("expected %s, got %s", GetValue(n), val)
+		}
+		n++
+	}
+	if n != 30 {
+		t.Fatalf("expected 30 keys, got %d", n)
+	}
+}
+
+func TestRefreshInterval(t *testing.T) {
+	dir := t.TempDir()
+	w := openPut(t, dir, 1)
+	defer w.Close()
+	r, err := OpenReadOnly(dir, WithRefreshInterval(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if err := w.Put(GetKey(1), GetValue(1)); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, err := r.Get(GetKey(1)); err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the key loaded by the background refresh")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,75 @@
+package bitcask
+
+import (
+	"errors"
+	"io"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// recoverEntry handles the error of reading the entry at offset while
+// rebuilding the index. The torn tail of the last datafile, left by a crash
+// in the middle of a write, is truncated. Corruption elsewhere fails the
+// load, unless skipCorrupt is set.
+// It returns the bytes to skip to the next entry, 0 means stop reading df.
+func (db *Bitcask) recoverEntry(df *dataFile, offset, n int64, tail bool, err error) (int64, error) {
+	var cerr *CorruptEntryError
+	corrupt := errors.As(err, &cerr)
+	if !corrupt && err != io.ErrUnexpectedEOF {
+		return 0, err
+	}
+	// the entry is cut by the end of datafile, or the last entry fails the crc
+	torn := err == io.ErrUnexpectedEOF || offset+n == df.Size()
+
+	fields := log.Fields{
+		"datafile": df.f.Name(),
+		"offset":   offset,
+	}
+	if tail && torn {
+		fields["discarded"] = df.Size() - offset
+		if err := db.truncate(df, offset); err != nil {
+			return 0, err
+		}
+		log.WithFields(fields).Warn("truncate torn tail of datafile")
+		return 0, nil
+	}
+	if !corrupt {
+		err = &CorruptEntryError{FileID: df.fileID, Offset: offset}
+	}
+	if !db.opts.skipCorrupt {
+		return 0, err
+	}
+	// can't locate the next entry
+	if !corrupt || torn {
+		fields["discarded"] = df.Size() - offset
+		log.WithFields(fields).Warn("skip the rest of datafile after corrupt entry")
+		return 0, nil
+	}
+	fields["discarded"] = n
+	log.WithFields(fields).Warn("skip corrupt entry")
+	return n, nil
+}
+
+// truncate discards the content of the tail datafile after offset, a
+// read-only db only stops reading there
+func (db *Bitcask) truncate(df *dataFile, offset int64) error {
+	if db.opts.readOnly {
+		df.offset = offset
+		return nil
+	}
+	return df.truncate(offset)
+}
+
+// truncateBatch discards the uncommitted batch at the tail of the last datafile
+func (db *Bitcask) truncateBatch(df *dataFile, offset int64) error {
+	fields := log.Fields{
+		"datafile":  df.f.Name(),
+		"offset":    offset,
+		"discarded": df.Size() - offset,
+	}
+	if err := db.truncate(df, offset); err != nil {
+		return err
+	}
+	log.WithFields(fields).Warn("truncate uncommitted batch at tail of datafile")
+	return nil
+}
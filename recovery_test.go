@@ -0,0 +1,134 @@
+package bitcask
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func openPut(t *testing.T, dir string, n int, opts ...Option) *Bitcask {
+	db, err := Open(dir, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		if err := db.Put(GetKey(i), GetValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+func TestRecoverTornTail(t *testing.T) {
+	dir := t.TempDir()
+	db := openPut(t, dir, 10)
+	name, size := db.active.f.Name(), db.active.Size()
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// half of an entry left by a crash
+	_, buf := newDataEntry(GetKey(10), GetValue(10), markPut).Encode()
+	fd, err := os.OpenFile(name, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fd.Write(buf[:len(buf)/2]); err != nil {
+		t.Fatal(err)
+	}
+	fd.Close()
+
+	db, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.Keys() != 10 {
+		t.Fatalf("expected 10 keys, got %d", db.Keys())
+	}
+	fi, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() != size {
+		t.Fatalf("expected datafile truncated to %d, got %d", size, fi.Size())
+	}
+	if err := db.Put(GetKey(10), GetValue(10)); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if db.Keys() != 11 {
+		t.Fatalf("expected 11 keys, got %d", db.Keys())
+	}
+}
+
+func TestRecoverCorruptTail(t *testing.T) {
+	dir := t.TempDir()
+	db := openPut(t, dir, 10)
+	name, size := db.active.f.Name(), db.active.Size()
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	fd, err := os.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fd.WriteAt([]byte{'X'}, size-1); err != nil {
+		t.Fatal(err)
+	}
+	fd.Close()
+
+	db, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if db.Keys() != 9 {
+		t.Fatalf("expected 9 keys, got %d", db.Keys())
+	}
+	if _, err := db.Get(GetKey(9)); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+}
+
+func TestRecoverCorruptSealed(t *testing.T) {
+	dir := t.TempDir()
+	// an entry per datafile
+	db := openPut(t, dir, 10, WithMaxFileSize(64))
+	it, _ := db.index.Get(string(GetKey(5)))
+	df := db.datafiles[it.fileID]
+	name := df.f.Name()
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	fd, err := os.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fd.WriteAt([]byte{'X'}, metaLen); err != nil {
+		t.Fatal(err)
+	}
+	fd.Close()
+
+	if _, err := Open(dir); !errors.Is(err, ErrCorruptEntry) {
+		t.Fatalf("expected ErrCorruptEntry, got %v", err)
+	}
+
+	db, err = Open(dir, WithSkipCorrupt(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if db.Keys() != 9 {
+		t.Fatalf("expected 9 keys, got %d", db.Keys())
+	}
+	if _, err := db.Get(GetKey(5)); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+}
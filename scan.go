@@ -0,0 +1,81 @@
+package bitcask
+
+import (
+	"errors"
+	"time"
+)
+
+// Scan calls fn with the keys having prefix and their values in ascending
+// order of key, and stops at the first error returned by fn. The matching
+// keys are taken under the lock, and their values are read after it, so a
+// key deleted meanwhile is skipped. The keys are visited in the order of
+// the keydir; with HashKeydir every key of the db is checked and sorted.
+func (db *Bitcask) Scan(prefix []byte, fn func(key, value []byte) error) error {
+	return db.scan(string(prefix), prefixEnd(prefix), false, fn)
+}
+
+// ReverseScan is Scan in descending order of key
+func (db *Bitcask) ReverseScan(prefix []byte, fn func(key, value []byte) error) error {
+	return db.scan(string(prefix), prefixEnd(prefix), true, fn)
+}
+
+// Range calls fn with the keys in [start, end) and their values in
+// ascending order of key, like Scan. An empty end means no upper bound.
+func (db *Bitcask) Range(start, end []byte, fn func(key, value []byte) error) error {
+	return db.scan(string(start), string(end), false, fn)
+}
+
+// ReverseRange is Range in descending order of key
+func (db *Bitcask) ReverseRange(start, end []byte, fn func(key, value []byte) error) error {
+	return db.scan(string(start), string(end), true, fn)
+}
+
+func (db *Bitcask) scan(start, end string, reverse bool, fn func(key, value []byte) error) error {
+	db.mu.RLock()
+	if db.closed {
+		db.mu.RUnlock()
+		return ErrClosed
+	}
+	now := time.Now().UnixNano()
+	keys := make([]string, 0)
+	collect := func(key string, it *item) bool {
+		if !it.expired(now) {
+			keys = append(keys, key)
+		}
+		return true
+	}
+	if reverse {
+		db.index.Descend(start, end, collect)
+	} else {
+		db.index.Ascend(start, end, collect)
+	}
+	db.mu.RUnlock()
+
+	// fn may write the db, so it is called out of the lock
+	for _, k := range keys {
+		value, err := db.Get([]byte(k))
+		if errors.Is(err, ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn([]byte(k), value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prefixEnd returns the smallest key greater than all keys having prefix,
+// empty if there is none
+func prefixEnd(prefix []byte) string {
+	end := []byte(string(prefix))
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+	return ""
+}
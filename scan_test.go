@@ -0,0 +1,208 @@
+package bitcask
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var keydirs = []struct {
+	name string
+	t    KeydirType
+}{
+	{"hash", HashKeydir},
+	{"btree", BTreeKeydir},
+}
+
+func scanKeys(t *testing.T, scan func(fn func(key, value []byte) error) error) []string {
+	t.Helper()
+	keys := make([]string, 0)
+	err := scan(func(key, value []byte) error {
+		if string(value) != "v-"+string(key) {
+			t.Fatalf("unexpected value %s of key %s", value, key)
+		}
+		keys = append(keys, string(key))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func TestScanRange(t *testing.T) {
+	for _, kd := range keydirs {
+		t.Run(kd.name, func(t *testing.T) {
+			db, err := Open(t.TempDir(), WithKeydir(kd.t))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			for _, k := range []string{"tenant/2/b", "tenant/1/b", "a", "tenant/1/a", "tenant/10/a", "z", "tenant/1/c"} {
+				if err := db.Put([]byte(k), []byte("v-"+k)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := db.Del([]byte("tenant/1/c")); err != nil {
+				t.Fatal(err)
+			}
+
+			tests := []struct {
+				name string
+				scan func(fn func(key, value []byte) error) error
+				want []string
+			}{
+				{"scan", func(fn func(key, value []byte) error) error {
+					return db.Scan([]byte("tenant/1/"), fn)
+				}, []string{"tenant/1/a", "tenant/1/b"}},
+				{"reverse scan", func(fn func(key, value []byte) error) error {
+					return db.ReverseScan([]byte("tenant/1"), fn)
+				}, []string{"tenant/10/a", "tenant/1/b", "tenant/1/a"}},
+				{"scan all", func(fn func(key, value []byte) error) error {
+					return db.Scan(nil, fn)
+				}, []string{"a", "tenant/1/a", "tenant/1/b", "tenant/10/a", "tenant/2/b", "z"}},
+				{"range", func(fn func(key, value []byte) error) error {
+					return db.Range([]byte("tenant/1/b"), []byte("tenant/2/b"), fn)
+				}, []string{"tenant/1/b", "tenant/10/a"}},
+				{"range without end", func(fn func(key, value []byte) error) error {
+					return db.Range([]byte("tenant/2"), nil, fn)
+				}, []string{"tenant/2/b", "z"}},
+				{"reverse range", func(fn func(key, value []byte) error) error {
+					return db.ReverseRange([]byte("a"), []byte("tenant/10/a"), fn)
+				}, []string{"tenant/1/b", "tenant/1/a", "a"}},
+				{"reverse range without end", func(fn func(key, value []byte) error) error {
+					return db.ReverseRange([]byte("tenant/2"), nil, fn)
+				}, []string{"z", "tenant/2/b"}},
+			}
+			for _, tt := range tests {
+				if got := scanKeys(t, tt.scan); !reflect.DeepEqual(got, tt.want) {
+					t.Fatalf("%s: expected %v, got %v", tt.name, tt.want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestScanStop(t *testing.T) {
+	db, err := Open(t.TempDir(), WithKeydir(BTreeKeydir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for i := 0; i < 10; i++ {
+		if err := db.Put(GetKey(i), GetValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stop := errors.New("stop")
+	n := 0
+	err = db.Scan([]byte("test_key_"), func(key, value []byte) error {
+		n++
+		if n == 3 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop {
+		t.Fatalf("expected stop, got %v", err)
+	}
+	if n != 3 {
+		t.Fatalf("expected 3 keys, got %d", n)
+	}
+}
+
+func TestScanSkipExpired(t *testing.T) {
+	db, err := Open(t.TempDir(), WithKeydir(BTreeKeydir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.Put([]byte("k1"), []byte("v-k1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutWithTTL([]byte("k2"), []byte("v-k2"), 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	got := scanKeys(t, func(fn func(key, value []byte) error) error {
+		return db.Scan([]byte("k"), fn)
+	})
+	if strings.Join(got, ",") != "k1" {
+		t.Fatalf("expected [k1], got %v", got)
+	}
+}
+
+func TestScanWrite(t *testing.T) {
+	db, err := Open(t.TempDir(), WithKeydir(BTreeKeydir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for i := 0; i < 10; i++ {
+		if err := db.Put(GetKey(i), GetValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// fn can write the db
+	err = db.Scan([]byte("test_key_"), func(key, value []byte) error {
+		return db.Del(key)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.Keys() != 0 {
+		t.Fatalf("expected no key, got %d", db.Keys())
+	}
+}
+
+func TestPrefixEnd(t *testing.T) {
+	tests := []struct {
+		prefix []byte
+		want   string
+	}{
+		{nil, ""},
+		{[]byte("a"), "b"},
+		{[]byte("a\xff"), "b"},
+		{[]byte("\xff\xff"), ""},
+	}
+	for _, tt := range tests {
+		if got := prefixEnd(tt.prefix); got != tt.want {
+			t.Fatalf("prefixEnd(%q): expected %q, got %q", tt.prefix, tt.want, got)
+		}
+	}
+}
+
+func TestReopenBTreeKeydir(t *testing.T) {
+	dir := t.TempDir()
+	db := openPut(t, dir, 10, WithKeydir(BTreeKeydir))
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err := Open(dir, WithKeydir(BTreeKeydir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if db.Keys() != 10 {
+		t.Fatalf("expected 10 keys, got %d", db.Keys())
+	}
+	if _, err := db.Merge(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		val, err := db.Get(GetKey(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(val) != string(GetValue(i)) {
+			t.Fatalf("expected %s, got %s", GetValue(i), val)
+		}
+	}
+}
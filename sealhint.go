@@ -0,0 +1,129 @@
+package bitcask
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// queueHint queues the sealed datafile df for its hint file, db.mu is held
+func (db *Bitcask) queueHint(df *dataFile) {
+	if db.hintc == nil || df.Size() == 0 {
+		return
+	}
+	db.hintq = append(db.hintq, df)
+	select {
+	case db.hintc <- struct{}{}:
+	default:
+	}
+}
+
+// hintLoop writes the hint files of the queued datafiles, until the db is closed
+func (db *Bitcask) hintLoop() {
+	defer db.bg.Done()
+	for {
+		select {
+		case <-db.closing:
+			return
+		case <-db.hintc:
+		}
+		db.mu.Lock()
+		q := db.hintq
+		db.hintq = nil
+		db.mu.Unlock()
+		for _, df := range q {
+			err := db.writeHintFile(df)
+			if err == ErrClosed {
+				return
+			}
+			if err != nil {
+				// the datafile is read by the next Open
+				log.WithError(err).WithField("fileID", df.fileID).Warn("write hint file")
+			}
+		}
+	}
+}
+
+// writeHintFile writes the hint file of the sealed datafile df in a
+// scratch dir, and renames it into the db dir
+func (db *Bitcask) writeHintFile(df *dataFile) error {
+	// merge doesn't rewrite or remove df meanwhile
+	db.foldMu.RLock()
+	defer db.foldMu.RUnlock()
+	db.mu.RLock()
+	cur, ok := db.datafiles[df.fileID]
+	db.mu.RUnlock()
+	// merged, and hinted by merge
+	if !ok || cur != df {
+		return nil
+	}
+	// the hint file must not point to entries lost by a crash
+	if err := df.Sync(); err != nil {
+		return err
+	}
+
+	tmpdir, err := db.mergeTempDir()
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpdir)
+	hf := newHintFile(db.opts.fileMode)
+	defer hf.Close()
+	// hints of the batch, written when its COMMIT entry is read
+	var batch []*hintEntry
+	var offset int64
+	for {
+		select {
+		case <-db.closing:
+			return ErrClosed
+		default:
+		}
+		// df is sealed, it is read without the lock
+		n, e, err := df.ReadAt(offset)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// the datafile is read by the next Open, which handles
+			// the corrupt entries
+			return err
+		}
+		he := newHintEntry(e.key, e.valueSize, offset, e.expiry, e.op())
+		offset += n
+		switch {
+		case e.mark&markBatch != 0:
+			batch = append(batch, he)
+			continue
+		case e.mark == markCommit:
+			// the same as loadIndexFromFile, an incomplete batch is dropped
+			if len(batch) == int(e.batchCount()) {
+				for _, he := range batch {
+					if err := hf.write(tmpdir, df.fileID, he); err != nil {
+						return err
+					}
+				}
+			}
+			batch = nil
+			continue
+		}
+		batch = nil
+		if err := hf.write(tmpdir, df.fileID, he); err != nil {
+			return err
+		}
+	}
+	// no entries to hint
+	if hf.f == nil {
+		return nil
+	}
+	if err := hf.Sync(); err != nil {
+		return err
+	}
+	if err := hf.Close(); err != nil {
+		return err
+	}
+	name := fmt.Sprintf(hintFilePrefix, df.fileID)
+	return os.Rename(path.Join(tmpdir, name), path.Join(db.dir, name))
+}
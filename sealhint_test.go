@@ -0,0 +1,130 @@
+package bitcask
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// waitHint waits for the hint file of datafile id written in background
+func waitHint(t *testing.T, dir string, id int64) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		_, err := os.Stat(path.Join(dir, fmt.Sprintf(hintFilePrefix, id)))
+		if err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected hint file %d: %v", id, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSealHint(t *testing.T) {
+	dir := t.TempDir()
+	// 10 entries per datafile
+	db := openPut(t, dir, 30, WithMaxFileSize(1000))
+	want := make(map[string]string)
+	for i := 0; i < 30; i++ {
+		want[string(GetKey(i))] = string(GetValue(i))
+	}
+	ids := make([]int64, 0)
+	for id := range db.datafiles {
+		if id != db.currID {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) != 2 {
+		t.Fatalf("expected 2 sealed datafiles, got %d", len(ids))
+	}
+	for _, id := range ids {
+		waitHint(t, dir, id)
+	}
+	last := db.currID
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := Open(dir, WithMaxFileSize(1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, id := range ids {
+		if _, ok := db.hintfiles[id]; !ok {
+			t.Fatalf("expected datafile %d loaded from its hint file", id)
+		}
+	}
+	checkValues(t, db, want)
+	// the active datafile before Open is sealed
+	waitHint(t, dir, last)
+}
+
+func TestSealHintBatch(t *testing.T) {
+	dir := t.TempDir()
+	db := openPut(t, dir, 3)
+	id := db.currID
+
+	b := NewBatch()
+	b.Put(GetKey(0), []byte("new"))
+	b.Delete(GetKey(1))
+	// the batch entries are written without the commit entry
+	for _, e := range b.entries {
+		if _, err := db.active.Write(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Put(GetKey(3), GetValue(3)); err != nil {
+		t.Fatal(err)
+	}
+	b.Reset()
+	b.Put(GetKey(4), []byte("batch"))
+	b.Delete(GetKey(2))
+	if err := db.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	db.mu.Lock()
+	err := db.checkIfNeeded(0, true)
+	db.mu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitHint(t, dir, id)
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, ok := db.hintfiles[id]; !ok {
+		t.Fatal("expected the datafile loaded from its hint file")
+	}
+	checkValues(t, db, map[string]string{
+		string(GetKey(0)): string(GetValue(0)),
+		string(GetKey(1)): string(GetValue(1)),
+		string(GetKey(3)): string(GetValue(3)),
+		string(GetKey(4)): "batch",
+	})
+}
+
+func TestSealHintDisabled(t *testing.T) {
+	dir := t.TempDir()
+	db := openPut(t, dir, 30, WithMaxFileSize(1000), WithSealHint(false))
+	defer db.Close()
+	time.Sleep(50 * time.Millisecond)
+	files, err := filepath.Glob(path.Join(dir, hintFilePattern))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Fatalf("unexpected hint files %v", files)
+	}
+}
@@ -0,0 +1,39 @@
+package bitcask
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Sync syncs the active datafile to disk
+func (db *Bitcask) Sync() error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return ErrClosed
+	}
+	// nothing is written
+	if db.opts.readOnly {
+		return nil
+	}
+	return db.active.Sync()
+}
+
+// syncLoop syncs the active datafile every sync interval until the db is closed
+func (db *Bitcask) syncLoop() {
+	defer db.bg.Done()
+	ticker := time.NewTicker(db.opts.syncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-db.closing:
+			return
+		case <-ticker.C:
+			// Close syncs the active datafile at last
+			if err := db.Sync(); err != nil && err != ErrClosed {
+				log.WithError(err).Error("sync active datafile")
+			}
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package bitcask
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSyncPolicy(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+	}{
+		{"never", []Option{WithSyncPolicy(SyncNever)}},
+		{"always", []Option{WithSyncPolicy(SyncAlways)}},
+		{"interval", []Option{WithSyncPolicy(SyncInterval), WithSyncInterval(time.Millisecond)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			db := openPut(t, dir, 100, append(tt.opts, WithMaxFileSize(1024))...)
+			time.Sleep(10 * time.Millisecond)
+			if err := db.Sync(); err != nil {
+				t.Fatal(err)
+			}
+			if err := db.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if err := db.Sync(); !errors.Is(err, ErrClosed) {
+				t.Fatalf("expected ErrClosed, got %v", err)
+			}
+
+			db, err := Open(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			if db.Keys() != 100 {
+				t.Fatalf("expected 100 keys, got %d", db.Keys())
+			}
+		})
+	}
+}
+
+func TestPutWriteError(t *testing.T) {
+	db, err := Open(t.TempDir(), WithSyncPolicy(SyncAlways))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	// break the active datafile under the db
+	db.active.f.Close()
+	if err := db.Put([]byte("key"), []byte("value")); err == nil {
+		t.Fatal("expected write error")
+	}
+	if _, err := db.Get([]byte("key")); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,63 @@
+package bitcask
+
+import (
+	"errors"
+	"time"
+)
+
+// errItemChanged retries Expire when the key is changed before it commits
+var errItemChanged = errors.New("bitcask: item changed")
+
+// PutWithTTL adds or updates the value of key, which expires after ttl
+func (db *Bitcask) PutWithTTL(key []byte, value []byte, ttl time.Duration) error {
+	if ttl <= 0 {
+		return ErrInvalidTTL
+	}
+	return db.put(key, value, time.Now().Add(ttl).UnixNano())
+}
+
+// Expire sets key to expire after ttl, or returns ErrKeyNotFound
+func (db *Bitcask) Expire(key []byte, ttl time.Duration) error {
+	if ttl <= 0 {
+		return ErrInvalidTTL
+	}
+	for {
+		// the value is written after the lock is released, when merge
+		// or Refresh may unmap its datafile
+		it, value, err := db.getItem(key, true)
+		if err != nil {
+			return err
+		}
+		// rewrite the value with the new expiry
+		e := newDataEntry(key, value, markPut)
+		e.setExpiry(time.Now().Add(ttl).UnixNano())
+		req := newWriteRequest(e)
+		req.check = func(lookup lookupFunc) error {
+			if cur, _ := lookup(string(key)); cur != it {
+				return errItemChanged
+			}
+			return nil
+		}
+		if err := db.write(req); err != errItemChanged {
+			return err
+		}
+	}
+}
+
+// TTL returns the remaining time to live of key, or -1 if key never expires
+func (db *Bitcask) TTL(key []byte) (time.Duration, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return 0, ErrClosed
+	}
+	now := time.Now().UnixNano()
+	it, ok := db.index.Get(string(key))
+	if !ok || it.expired(now) {
+		return 0, ErrKeyNotFound
+	}
+	if it.expiry == 0 {
+		return -1, nil
+	}
+	return time.Duration(it.expiry - now), nil
+}
@@ -0,0 +1,274 @@
+package bitcask
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPutWithTTL(t *testing.T) {
+	db, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.PutWithTTL([]byte("key"), []byte("value"), 0); !errors.Is(err, ErrInvalidTTL) {
+		t.Fatalf("expected ErrInvalidTTL, got %v", err)
+	}
+	if err := db.PutWithTTL([]byte("key"), []byte("value"), 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	val, err := db.Get([]byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(val) != "value" {
+		t.Fatalf("expected value, got %s", val)
+	}
+	ttl, err := db.TTL([]byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl <= 0 || ttl > 50*time.Millisecond {
+		t.Fatalf("unexpected ttl %v", ttl)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if _, err := db.Get([]byte("key")); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+	if _, err := db.TTL([]byte("key")); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+	if err := db.Del([]byte("key")); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+}
+
+func TestKeysExpired(t *testing.T) {
+	db, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.Put([]byte("k1"), []byte("v1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutWithTTL([]byte("k2"), []byte("v2"), 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if db.Keys() != 2 {
+		t.Fatalf("expected 2 keys, got %d", db.Keys())
+	}
+	time.Sleep(60 * time.Millisecond)
+	// k2 is not accessed, still in the index
+	if db.Keys() != 1 {
+		t.Fatalf("expected 1 key, got %d", db.Keys())
+	}
+}
+
+func TestExpire(t *testing.T) {
+	db, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.Expire([]byte("key"), time.Second); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+	if err := db.Put([]byte("key"), []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	ttl, err := db.TTL([]byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl != -1 {
+		t.Fatalf("expected -1, got %v", ttl)
+	}
+	if err := db.Expire([]byte("key"), 0); !errors.Is(err, ErrInvalidTTL) {
+		t.Fatalf("expected ErrInvalidTTL, got %v", err)
+	}
+	if err := db.Expire([]byte("key"), 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	val, err := db.Get([]byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(val) != "value" {
+		t.Fatalf("expected value, got %s", val)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if _, err := db.Get([]byte("key")); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+}
+
+func TestTTLReopen(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutWithTTL([]byte("short"), []byte("value"), 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutWithTTL([]byte("long"), []byte("value"), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put([]byte("forever"), []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(60 * time.Millisecond)
+
+	db, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if db.Keys() != 2 {
+		t.Fatalf("expected 2 keys, got %d", db.Keys())
+	}
+	ttl, err := db.TTL([]byte("long"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl <= 0 || ttl > time.Hour {
+		t.Fatalf("unexpected ttl %v", ttl)
+	}
+}
+
+func TestMergeExpired(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutWithTTL([]byte("short"), []byte("value"), 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutWithTTL([]byte("long"), []byte("value"), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put([]byte("forever"), []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if _, err := db.Merge(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if db.Keys() != 2 {
+		t.Fatalf("expected 2 keys, got %d", db.Keys())
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// rebuilt from the hint files of merge
+	db, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if db.Keys() != 2 {
+		t.Fatalf("expected 2 keys, got %d", db.Keys())
+	}
+	ttl, err := db.TTL([]byte("long"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl <= 0 || ttl > time.Hour {
+		t.Fatalf("unexpected ttl %v", ttl)
+	}
+	ttl, err = db.TTL([]byte("forever"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl != -1 {
+		t.Fatalf("expected -1, got %v", ttl)
+	}
+}
+
+// encodeBaseline encodes an entry in the format written before ttl:
+// crc, mark, key size, value size, key, value
+func encodeBaseline(key, value []byte, mark uint8) []byte {
+	buf := make([]byte, metaLen+len(key)+len(value))
+	buf[crcLen] = mark
+	binary.BigEndian.PutUint32(buf[crcLen+markLen:], uint32(len(key)))
+	binary.BigEndian.PutUint64(buf[crcLen+markLen+keySizeLen:], uint64(len(value)))
+	copy(buf[metaLen:], key)
+	copy(buf[metaLen+len(key):], value)
+	binary.BigEndian.PutUint32(buf, crc32.ChecksumIEEE(buf[crcLen:]))
+	return buf
+}
+
+func TestOpenBaselineFormat(t *testing.T) {
+	for _, n := range []int{1, 3} {
+		dir := t.TempDir()
+		var data []byte
+		for i := 0; i < n; i++ {
+			data = append(data, encodeBaseline([]byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("value%d", i)), markPut)...)
+		}
+		data = append(data, encodeBaseline([]byte("key0"), nil, markDel)...)
+		name := filepath.Join(dir, fmt.Sprintf(dataFilePrefix, 0))
+		if err := os.WriteFile(name, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		db, err := Open(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Get([]byte("key0")); !errors.Is(err, ErrKeyNotFound) {
+			t.Fatalf("expected ErrKeyNotFound, got %v", err)
+		}
+		for i := 1; i < n; i++ {
+			val, err := db.Get([]byte(fmt.Sprintf("key%d", i)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(val) != fmt.Sprintf("value%d", i) {
+				t.Fatalf("key%d: unexpected value %s", i, val)
+			}
+		}
+		// the new entries are appended without breaking the old ones
+		if err := db.PutWithTTL([]byte("ttl"), []byte("value"), time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+		fi, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Size() != int64(len(data)) {
+			t.Fatalf("datafile truncated to %d, expected %d", fi.Size(), len(data))
+		}
+
+		db, err = Open(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if db.Keys() != n {
+			t.Fatalf("expected %d keys, got %d", n, db.Keys())
+		}
+		if ttl, err := db.TTL([]byte("ttl")); err != nil || ttl <= 0 {
+			t.Fatalf("unexpected ttl %v, %v", ttl, err)
+		}
+		db.Close()
+	}
+}
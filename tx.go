@@ -0,0 +1,224 @@
+package bitcask
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Tx is an optimistic transaction. Its reads see the snapshot of the db
+// when it began, even if the keys are changed after that. The writes of an
+// Update transaction are buffered and written as a batch on commit, which
+// fails with ErrConflict if a key read by the transaction has been changed
+// since the snapshot.
+type Tx struct {
+	db       *Bitcask
+	seq      uint64
+	writable bool
+	done     bool
+	// seq of the keys read
+	reads  map[string]uint64
+	writes map[string]*dataEntry
+	batch  *Batch
+}
+
+// Update runs fn in a read-write transaction, and commits it if fn
+// returns nil. It returns ErrConflict if the transaction conflicts with
+// another commit, the caller may retry it.
+func (db *Bitcask) Update(fn func(tx *Tx) error) error {
+	tx, err := db.begin(true)
+	if err != nil {
+		return err
+	}
+	defer tx.end()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.commit()
+}
+
+// View runs fn in a read-only transaction
+func (db *Bitcask) View(fn func(tx *Tx) error) error {
+	tx, err := db.begin(false)
+	if err != nil {
+		return err
+	}
+	defer tx.end()
+	return fn(tx)
+}
+
+func (db *Bitcask) begin(writable bool) (*Tx, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return nil, ErrClosed
+	}
+	if db.txs == 0 {
+		db.versions = make(map[string][]version)
+	}
+	db.txs++
+	return &Tx{
+		db:       db,
+		seq:      db.seq,
+		writable: writable,
+		reads:    make(map[string]uint64),
+		writes:   make(map[string]*dataEntry),
+		batch:    NewBatch(),
+	}, nil
+}
+
+func (tx *Tx) end() {
+	tx.done = true
+	db := tx.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.txs--
+	if db.txs > 0 {
+		return
+	}
+	for _, vs := range db.versions {
+		for _, v := range vs {
+			if v.df == nil {
+				continue
+			}
+			if err := db.unpin(v.df); err != nil {
+				log.WithError(err).WithField("fileID", v.df.fileID).Warn("remove merged datafile")
+			}
+		}
+	}
+	db.versions = nil
+}
+
+// version is a state of a key changed while transactions are open
+type version struct {
+	// nil means absent
+	it *item
+	// the datafile of it, pinned until the transactions end
+	df *dataFile
+	// seq of the commit making the state
+	seq uint64
+}
+
+// addVersion records the change of key to it at seq for the open
+// transactions, after the state it replaces. db.mu must be held.
+func (db *Bitcask) addVersion(key string, it *item, seq uint64) {
+	vs, ok := db.versions[key]
+	if !ok {
+		old, oldSeq := db.lookup(key)
+		vs = append(vs, db.newVersion(old, oldSeq))
+	}
+	db.versions[key] = append(vs, db.newVersion(it, seq))
+}
+
+func (db *Bitcask) newVersion(it *item, seq uint64) version {
+	v := version{it: it, seq: seq}
+	if it == nil {
+		return v
+	}
+	if df, ok := db.datafiles[it.fileID]; ok {
+		df.refs++
+		v.df = df
+	}
+	return v
+}
+
+// snapshot returns the item of key as of seq, its datafile and the seq of
+// the commit making it. db.mu must be held.
+func (db *Bitcask) snapshot(key string, seq uint64) (*item, *dataFile, uint64) {
+	if vs, ok := db.versions[key]; ok {
+		for i := len(vs) - 1; i >= 0; i-- {
+			if vs[i].seq <= seq {
+				return vs[i].it, vs[i].df, vs[i].seq
+			}
+		}
+		return nil, nil, 0
+	}
+	it, ok := db.index.Get(key)
+	if !ok {
+		return nil, nil, 0
+	}
+	return it, db.datafiles[it.fileID], it.seq
+}
+
+// Get returns the value of key in the snapshot of the transaction,
+// including the writes of the transaction
+func (tx *Tx) Get(key []byte) ([]byte, error) {
+	if tx.done {
+		return nil, ErrTxDone
+	}
+	if e, ok := tx.writes[string(key)]; ok {
+		if e.op() == markDel {
+			return nil, ErrKeyNotFound
+		}
+		return e.value, nil
+	}
+
+	db := tx.db
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return nil, ErrClosed
+	}
+	it, df, seq := db.snapshot(string(key), tx.seq)
+	// commit fails if the key is changed after the snapshot
+	tx.reads[string(key)] = seq
+	if it == nil || it.expired(time.Now().UnixNano()) {
+		return nil, ErrKeyNotFound
+	}
+	if df == nil {
+		return nil, ErrDataFileNotFound
+	}
+	return db.getValue(df, key, it)
+}
+
+// Put adds or updates the value of key when the transaction commits
+func (tx *Tx) Put(key, value []byte) error {
+	if err := tx.checkWritable(); err != nil {
+		return err
+	}
+	if uint64(len(key)) > uint64(tx.db.opts.maxKeySize) {
+		return ErrKeyTooLarge
+	}
+	if uint64(len(value)) > tx.db.opts.maxValueSize {
+		return ErrValueTooLarge
+	}
+	tx.batch.Put(key, value)
+	tx.writes[string(key)] = tx.batch.entries[tx.batch.Len()-1]
+	return nil
+}
+
+// Delete deletes key when the transaction commits
+func (tx *Tx) Delete(key []byte) error {
+	if err := tx.checkWritable(); err != nil {
+		return err
+	}
+	tx.batch.Delete(key)
+	tx.writes[string(key)] = tx.batch.entries[tx.batch.Len()-1]
+	return nil
+}
+
+func (tx *Tx) checkWritable() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	if !tx.writable {
+		return ErrTxReadOnly
+	}
+	return nil
+}
+
+func (tx *Tx) commit() error {
+	if tx.batch.Len() == 0 {
+		return nil
+	}
+	req := tx.batch.request()
+	req.check = func(lookup lookupFunc) error {
+		for k, seq := range tx.reads {
+			if _, cur := lookup(k); cur != seq {
+				return ErrConflict
+			}
+		}
+		return nil
+	}
+	return tx.db.write(req)
+}
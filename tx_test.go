@@ -0,0 +1,268 @@
+package bitcask
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestTxUpdate(t *testing.T) {
+	dir := t.TempDir()
+	db := openPut(t, dir, 3)
+
+	err := db.Update(func(tx *Tx) error {
+		if err := tx.Put(GetKey(0), []byte("new")); err != nil {
+			return err
+		}
+		if err := tx.Delete(GetKey(1)); err != nil {
+			return err
+		}
+		// read own writes
+		val, err := tx.Get(GetKey(0))
+		if err != nil {
+			return err
+		}
+		if string(val) != "new" {
+			t.Fatalf("expected new, got %s", val)
+		}
+		if _, err := tx.Get(GetKey(1)); !errors.Is(err, ErrKeyNotFound) {
+			t.Fatalf("expected ErrKeyNotFound, got %v", err)
+		}
+		// not visible before commit
+		val, err = db.Get(GetKey(0))
+		if err != nil {
+			return err
+		}
+		if string(val) != string(GetValue(0)) {
+			t.Fatalf("expected %s, got %s", GetValue(0), val)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	val, err := db.Get(GetKey(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(val) != "new" {
+		t.Fatalf("expected new, got %s", val)
+	}
+	if _, err := db.Get(GetKey(1)); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+}
+
+func TestTxRollback(t *testing.T) {
+	db := openPut(t, t.TempDir(), 1)
+	defer db.Close()
+
+	errAbort := errors.New("abort")
+	var leaked *Tx
+	err := db.Update(func(tx *Tx) error {
+		leaked = tx
+		if err := tx.Put(GetKey(0), []byte("new")); err != nil {
+			return err
+		}
+		return errAbort
+	})
+	if err != errAbort {
+		t.Fatalf("expected errAbort, got %v", err)
+	}
+	val, err := db.Get(GetKey(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(val) != string(GetValue(0)) {
+		t.Fatalf("expected %s, got %s", GetValue(0), val)
+	}
+	if _, err := leaked.Get(GetKey(0)); !errors.Is(err, ErrTxDone) {
+		t.Fatalf("expected ErrTxDone, got %v", err)
+	}
+	if err := leaked.Put(GetKey(0), nil); !errors.Is(err, ErrTxDone) {
+		t.Fatalf("expected ErrTxDone, got %v", err)
+	}
+}
+
+func TestTxView(t *testing.T) {
+	db := openPut(t, t.TempDir(), 1)
+	defer db.Close()
+
+	err := db.View(func(tx *Tx) error {
+		val, err := tx.Get(GetKey(0))
+		if err != nil {
+			return err
+		}
+		if string(val) != string(GetValue(0)) {
+			t.Fatalf("expected %s, got %s", GetValue(0), val)
+		}
+		if err := tx.Put(GetKey(0), nil); !errors.Is(err, ErrTxReadOnly) {
+			t.Fatalf("expected ErrTxReadOnly, got %v", err)
+		}
+		if err := tx.Delete(GetKey(0)); !errors.Is(err, ErrTxReadOnly) {
+			t.Fatalf("expected ErrTxReadOnly, got %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTxConflict(t *testing.T) {
+	tests := []struct {
+		name string
+		// change the key read by the transaction
+		change func(db *Bitcask) error
+	}{
+		{"put", func(db *Bitcask) error {
+			return db.Put(GetKey(0), []byte("other"))
+		}},
+		{"del", func(db *Bitcask) error {
+			return db.Del(GetKey(0))
+		}},
+		{"batch", func(db *Bitcask) error {
+			b := NewBatch()
+			b.Put(GetKey(0), []byte("other"))
+			return db.Write(b)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := openPut(t, t.TempDir(), 2)
+			defer db.Close()
+
+			err := db.Update(func(tx *Tx) error {
+				if _, err := tx.Get(GetKey(0)); err != nil {
+					return err
+				}
+				if err := tt.change(db); err != nil {
+					return err
+				}
+				return tx.Put(GetKey(1), []byte("new"))
+			})
+			if !errors.Is(err, ErrConflict) {
+				t.Fatalf("expected ErrConflict, got %v", err)
+			}
+			val, err := db.Get(GetKey(1))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(val) != string(GetValue(1)) {
+				t.Fatalf("expected %s, got %s", GetValue(1), val)
+			}
+		})
+	}
+}
+
+func TestTxSnapshotRead(t *testing.T) {
+	db := openPut(t, t.TempDir(), 2, WithMaxFileSize(1000))
+	defer db.Close()
+
+	err := db.View(func(tx *Tx) error {
+		if err := db.Del(GetKey(0)); err != nil {
+			return err
+		}
+		if err := db.Put(GetKey(1), []byte("new")); err != nil {
+			return err
+		}
+		if err := db.Put(GetKey(2), []byte("new")); err != nil {
+			return err
+		}
+		// the old entries are rewritten while the transaction reads them
+		for i := 3; i < 30; i++ {
+			if err := db.Put(GetKey(i), GetValue(i)); err != nil {
+				return err
+			}
+		}
+		if _, err := db.Merge(context.Background()); err != nil {
+			return err
+		}
+		for i := 0; i < 2; i++ {
+			val, err := tx.Get(GetKey(i))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(val) != string(GetValue(i)) {
+				t.Fatalf("expected %s, got %s", GetValue(i), val)
+			}
+		}
+		// absent in the snapshot
+		if _, err := tx.Get(GetKey(2)); !errors.Is(err, ErrKeyNotFound) {
+			t.Fatalf("expected ErrKeyNotFound, got %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Get(GetKey(0)); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+	val, err := db.Get(GetKey(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(val) != "new" {
+		t.Fatalf("expected new, got %s", val)
+	}
+}
+
+func TestTxConcurrentIncr(t *testing.T) {
+	db, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	key := []byte("counter")
+	incr := func(tx *Tx) error {
+		n := 0
+		val, err := tx.Get(key)
+		if err == nil {
+			n, _ = strconv.Atoi(string(val))
+		} else if !errors.Is(err, ErrKeyNotFound) {
+			return err
+		}
+		return tx.Put(key, []byte(strconv.Itoa(n+1)))
+	}
+	wg := sync.WaitGroup{}
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				for {
+					err := db.Update(incr)
+					if err == nil {
+						break
+					}
+					if !errors.Is(err, ErrConflict) {
+						t.Error(err)
+						return
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	val, err := db.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(val) != "400" {
+		t.Fatalf("expected 400, got %s", val)
+	}
+}
@@ -1,19 +1,11 @@
-package main
+package bitcask
 
 import (
 	"path"
 	"strconv"
 	"strings"
-
-	log "github.com/sirupsen/logrus"
 )
 
-func init() {
-	log.SetFormatter(&log.TextFormatter{
-		ForceColors: true,
-	})
-}
-
 func getFileID(file string) int64 {
 	idx := strings.LastIndex(file, ".")
 	fileID, _ := strconv.Atoi(file[idx+1:])
@@ -1,4 +1,4 @@
-package main
+package bitcask
 
 import (
 	"fmt"
@@ -8,4 +8,4 @@ import (
 func TestFindid(t *testing.T) {
 	id := getFileID("bitcask.data.99")
 	fmt.Println(id)
-}
\ No newline at end of file
+}